@@ -0,0 +1,231 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package zin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Provider constructs a dependency. The context is the one of the request
+// resolving the dependency, so providers can resolve other dependencies from
+// it. The cleanup, if not nil, is called when the dependency is released.
+type Provider func(ctx context.Context) (value interface{}, cleanup func(), err error)
+
+type Lifetime int
+
+const (
+	// Singleton dependencies are constructed once and shared by all requests
+	Singleton Lifetime = iota
+	// PerRequest dependencies are constructed on first resolve in a request
+	// and released after the response
+	PerRequest
+)
+
+type provider struct {
+	lifetime Lifetime
+	provide  Provider
+
+	mutex   sync.Mutex
+	done    bool
+	value   interface{}
+	cleanup func()
+}
+
+// Container holds the providers of dependencies resolved from the request
+// context. Providers must not depend on each other cyclically.
+type Container struct {
+	mutex     sync.RWMutex
+	providers map[interface{}]*provider
+}
+
+func NewContainer() *Container {
+	return &Container{
+		providers: make(map[interface{}]*provider),
+	}
+}
+
+// Register adds the provider of key. Keys follow the same rules as context
+// keys and a later registration replaces the former one.
+func (c *Container) Register(key interface{}, lifetime Lifetime, p Provider) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.providers[key] = &provider{lifetime: lifetime, provide: p}
+}
+
+// Close releases the constructed singletons
+func (c *Container) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, p := range c.providers {
+		p.mutex.Lock()
+		if p.done && p.cleanup != nil {
+			p.cleanup()
+		}
+		p.done, p.value, p.cleanup = false, nil, nil
+		p.mutex.Unlock()
+	}
+}
+
+// Middleware attaches a request scope of the container to the request
+// context, the per-request dependencies are released when the handle returns.
+// Routes of a group created by an outer group's Provide see both containers,
+// with the inner one taking precedence.
+func (c *Container) Middleware() Middleware {
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			ctx := r.Context()
+			parent, _ := ctx.Value(containerScopeKey).(*containerScope)
+			s := &containerScope{container: c, parent: parent}
+			defer s.release()
+
+			ctx = context.WithValue(ctx, containerScopeKey, s)
+			h(w, r.WithContext(ctx), p)
+		}
+	}
+}
+
+func (c *Container) lookup(key interface{}) *provider {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.providers[key]
+}
+
+// Provide returns new MuxGroup whose routes and middlewares can resolve
+// dependencies from the container
+func (g *MuxGroup) Provide(c *Container) *MuxGroup {
+	return g.Group("", c.Middleware())
+}
+
+type containerContextKey struct{}
+
+var containerScopeKey = containerContextKey{}
+
+type containerScope struct {
+	container *Container
+	parent    *containerScope
+
+	mutex    sync.Mutex
+	values   map[interface{}]interface{}
+	cleanups []func()
+}
+
+func (s *containerScope) resolve(ctx context.Context, p *provider, key interface{}) (interface{}, error) {
+	if p.lifetime == Singleton {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		if !p.done {
+			value, cleanup, err := p.provide(ctx)
+			if err != nil {
+				return nil, err
+			}
+			p.done, p.value, p.cleanup = true, value, cleanup
+		}
+		return p.value, nil
+	}
+
+	s.mutex.Lock()
+	value, ok := s.values[key]
+	s.mutex.Unlock()
+	if ok {
+		return value, nil
+	}
+
+	value, cleanup, err := p.provide(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// another goroutine of the same request may have won the race
+	if existing, ok := s.values[key]; ok {
+		if cleanup != nil {
+			cleanup()
+		}
+		return existing, nil
+	}
+
+	if s.values == nil {
+		s.values = make(map[interface{}]interface{})
+	}
+	s.values[key] = value
+	if cleanup != nil {
+		s.cleanups = append(s.cleanups, cleanup)
+	}
+	return value, nil
+}
+
+func (s *containerScope) release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := len(s.cleanups) - 1; i >= 0; i-- {
+		s.cleanups[i]()
+	}
+	s.values, s.cleanups = nil, nil
+}
+
+// Resolve returns the dependency of key from the containers attached to ctx
+func Resolve(ctx context.Context, key interface{}) (interface{}, error) {
+	s, _ := ctx.Value(containerScopeKey).(*containerScope)
+	for ; s != nil; s = s.parent {
+		if p := s.container.lookup(key); p != nil {
+			return s.resolve(ctx, p, key)
+		}
+	}
+	return nil, fmt.Errorf("zin: no provider for %v", key)
+}
+
+// ResolveInto resolves the dependency of key and stores it in the value
+// pointed to by target, the dependency must be assignable to it
+func ResolveInto(ctx context.Context, key interface{}, target interface{}) error {
+	value, err := Resolve(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("zin: target of %v must be a non-nil pointer", key)
+	}
+
+	elem := rv.Elem()
+	if value == nil {
+		elem.Set(reflect.Zero(elem.Type()))
+		return nil
+	}
+
+	vv := reflect.ValueOf(value)
+	if !vv.Type().AssignableTo(elem.Type()) {
+		return fmt.Errorf("zin: dependency %v of type %s is not assignable to %s", key, vv.Type(), elem.Type())
+	}
+	elem.Set(vv)
+	return nil
+}
+
+// MustResolve is like Resolve but panics if the dependency cannot be resolved
+func MustResolve(ctx context.Context, key interface{}) interface{} {
+	value, err := Resolve(ctx, key)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
@@ -0,0 +1,82 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package zin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type containerTestKey string
+
+func TestContainer(t *testing.T) {
+	singletons := 0
+	requests := 0
+	released := 0
+
+	c := NewContainer()
+	c.Register(containerTestKey("db"), Singleton, func(ctx context.Context) (interface{}, func(), error) {
+		singletons++
+		return "db", nil, nil
+	})
+	c.Register(containerTestKey("tx"), PerRequest, func(ctx context.Context) (interface{}, func(), error) {
+		requests++
+		db := MustResolve(ctx, containerTestKey("db")).(string)
+		return fmt.Sprintf("%s-tx%d", db, requests), func() { released++ }, nil
+	})
+
+	router := httprouter.New()
+	group := NewGroup("/").Provide(c)
+	group.R(router.GET, "/test", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		var tx string
+		if err := ResolveInto(r.Context(), containerTestKey("tx"), &tx); err != nil {
+			t.Fatal(err)
+		}
+		again := MustResolve(r.Context(), containerTestKey("tx")).(string)
+		if tx != again {
+			t.Fatalf("expected same per-request dependency got %s and %s", tx, again)
+		}
+		if released != requests-1 {
+			t.Fatalf("per-request dependency released before response")
+		}
+		fmt.Fprint(w, tx)
+	})
+
+	for i := 1; i <= 3; i++ {
+		r, err := http.NewRequest("GET", "http://example.com/test", nil)
+		if err != nil {
+			panic(err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		if w.Body.String() != fmt.Sprintf("db-tx%d", i) {
+			t.Fatalf("unexpected body %s", w.Body.String())
+		}
+	}
+
+	if singletons != 1 {
+		t.Fatalf("expected singleton constructed once got %d", singletons)
+	}
+	if released != 3 {
+		t.Fatalf("expected 3 released got %d", released)
+	}
+
+	if _, err := Resolve(context.Background(), containerTestKey("db")); err == nil {
+		t.Fatalf("expected error resolving without container")
+	}
+}
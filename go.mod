@@ -1,6 +1,6 @@
 module github.com/rayark/zin/v2
 
-go 1.12
+go 1.13
 
 require (
 	github.com/julienschmidt/httprouter v1.3.0
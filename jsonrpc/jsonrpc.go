@@ -0,0 +1,358 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+// Package jsonrpc implements a JSON-RPC 2.0 dispatcher mounted on zin groups
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	"github.com/rayark/zin/v2/middleware"
+)
+
+const version = "2.0"
+
+// Error codes defined by the specification
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Error is the error object of a response. Methods return it to reply a
+// specific code, any other error is replied as a generic internal error and
+// left to Logger to log.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc: %d %s", e.Code, e.Message)
+}
+
+func NewError(code int, message string, data interface{}) *Error {
+	return &Error{Code: code, Message: message, Data: data}
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  *string         `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var null = json.RawMessage("null")
+
+// MethodHandler serves one method call with its raw params
+type MethodHandler func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// MethodMiddleware wraps method handlers like zin.Middleware wraps route
+// handles
+type MethodMiddleware func(MethodHandler) MethodHandler
+
+type methodContextKey struct{}
+
+// MethodFromContext returns the name of the method being called
+func MethodFromContext(ctx context.Context) (string, bool) {
+	method, ok := ctx.Value(methodContextKey{}).(string)
+	return method, ok
+}
+
+// DefaultBatchConcurrency is the number of calls of a batch run at once
+// unless configured
+var DefaultBatchConcurrency = runtime.GOMAXPROCS(0)
+
+// Server dispatches calls to the registered methods
+type Server struct {
+	// BatchConcurrency limits the calls of a batch run at once,
+	// DefaultBatchConcurrency if not positive
+	BatchConcurrency int
+
+	// MaxBodySize limits request bodies, middleware.DefaultMaxBodySize if not
+	// positive. Larger requests are replied with 413.
+	MaxBodySize int64
+
+	middlewares []MethodMiddleware
+
+	mutex   sync.RWMutex
+	methods map[string]MethodHandler
+}
+
+// NewServer returns a server running each method call through middlewares,
+// which are applied in the same order as the middlewares of a zin group
+func NewServer(middlewares ...MethodMiddleware) *Server {
+	return &Server{
+		middlewares: middlewares,
+		methods:     make(map[string]MethodHandler),
+	}
+}
+
+// RegisterHandler registers h as the handler of the method
+func (s *Server) RegisterHandler(method string, h MethodHandler) {
+	for _, m := range s.middlewares {
+		h = m(h)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.methods[method] = h
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Register registers fn as the method. fn must be a func taking a
+// context.Context and optionally the params, which are decoded from JSON,
+// and returning an optional result followed by an error. It panics on other
+// signatures.
+func (s *Server) Register(method string, fn interface{}) {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+
+	if ft.Kind() != reflect.Func ||
+		ft.NumIn() < 1 || ft.NumIn() > 2 || ft.In(0) != contextType ||
+		ft.NumOut() < 1 || ft.NumOut() > 2 || ft.Out(ft.NumOut()-1) != errorType {
+		panic(fmt.Sprintf("jsonrpc: invalid signature %s of method %q", ft, method))
+	}
+
+	s.RegisterHandler(method, func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		args := []reflect.Value{reflect.ValueOf(ctx)}
+		if ft.NumIn() == 2 {
+			arg := reflect.New(ft.In(1))
+			if len(params) > 0 {
+				if err := json.Unmarshal(params, arg.Interface()); err != nil {
+					return nil, NewError(CodeInvalidParams, "Invalid params", err.Error())
+				}
+			}
+			args = append(args, arg.Elem())
+		}
+
+		out := fv.Call(args)
+
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return nil, err
+		}
+		if len(out) == 2 {
+			return out[0].Interface(), nil
+		}
+		return nil, nil
+	})
+}
+
+func (s *Server) lookup(method string) MethodHandler {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.methods[method]
+}
+
+// Mount registers the server on the path of the group with r, which is
+// usually the POST of the router
+func (s *Server) Mount(g *zin.MuxGroup, r zin.RegisterFunc, path string) {
+	g.R(r, path, s.Handle)
+}
+
+// Handle serves a JSON-RPC request or batch
+func (s *Server) Handle(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	limit := s.MaxBodySize
+	if limit <= 0 {
+		limit = middleware.DefaultMaxBodySize
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil && int64(len(body)) == limit {
+		// MaxBytesReader fails once past the limit
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var reply interface{}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			reply = errorResponse(null, NewError(CodeParseError, "Parse error", nil))
+		} else if len(batch) == 0 {
+			reply = errorResponse(null, NewError(CodeInvalidRequest, "Invalid Request", nil))
+		} else {
+			responses := s.callBatch(r.Context(), batch)
+			if len(responses) > 0 {
+				reply = responses
+			}
+		}
+	} else if resp := s.call(r.Context(), body); resp != nil {
+		reply = resp
+	}
+
+	if reply == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+func (s *Server) callBatch(ctx context.Context, batch []json.RawMessage) []*response {
+	results := make([]*response, len(batch))
+
+	concurrency := s.BatchConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	if concurrency > len(batch) {
+		concurrency = len(batch)
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = s.call(ctx, batch[i])
+			}
+		}()
+	}
+	for i := range batch {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	responses := make([]*response, 0, len(batch))
+	for _, resp := range results {
+		if resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	return responses
+}
+
+// call serves a single request, it returns nil for notifications
+func (s *Server) call(ctx context.Context, raw json.RawMessage) (resp *response) {
+	var req request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return errorResponse(null, NewError(CodeParseError, "Parse error", nil))
+		}
+		return errorResponse(null, NewError(CodeInvalidRequest, "Invalid Request", nil))
+	}
+
+	if !validID(req.ID) {
+		return errorResponse(null, NewError(CodeInvalidRequest, "Invalid Request", nil))
+	}
+	id := req.ID
+	notification := id == nil
+	if notification {
+		id = null
+	}
+
+	if req.JSONRPC != version || req.Method == nil || !validParams(req.Params) {
+		return errorResponse(id, NewError(CodeInvalidRequest, "Invalid Request", nil))
+	}
+
+	h := s.lookup(*req.Method)
+	if h == nil {
+		if notification {
+			return nil
+		}
+		return errorResponse(id, NewError(CodeMethodNotFound, "Method not found", nil))
+	}
+
+	// a panic of one call must not take down the other calls of a batch,
+	// which run outside the goroutine of the request and its Recoverer
+	defer func() {
+		if recover() != nil {
+			resp = nil
+			if !notification {
+				resp = errorResponse(id, internalError())
+			}
+		}
+	}()
+
+	ctx = context.WithValue(ctx, methodContextKey{}, *req.Method)
+	result, err := h(ctx, req.Params)
+	if notification {
+		return nil
+	}
+
+	if err != nil {
+		rpcErr, ok := err.(*Error)
+		if !ok {
+			rpcErr = internalError()
+		}
+		return errorResponse(id, rpcErr)
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(id, internalError())
+	}
+	return &response{JSONRPC: version, Result: json.RawMessage(encoded), ID: id}
+}
+
+// internalError is replied for errors other than *Error, whose messages are
+// not meant for clients
+func internalError() *Error {
+	return NewError(CodeInternalError, "Internal error", nil)
+}
+
+func errorResponse(id json.RawMessage, err *Error) *response {
+	return &response{JSONRPC: version, Error: err, ID: id}
+}
+
+// validID reports whether the id is absent, null, a string or a number
+func validID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch id[0] {
+	case 'n', '"', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+// validParams reports whether the params are absent, an object or an array
+func validParams(params json.RawMessage) bool {
+	if params == nil {
+		return true
+	}
+	return params[0] == '{' || params[0] == '['
+}
@@ -0,0 +1,214 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	. "github.com/rayark/zin/v2/jsonrpc"
+	"github.com/rayark/zin/v2/middleware"
+)
+
+type recordEntry struct {
+	mutex  *sync.Mutex
+	routes *[]string
+	route  string
+}
+
+func (e recordEntry) WithField(k string, v interface{}) middleware.LogEntry {
+	if k == "route" {
+		e.route = v.(string)
+	}
+	return e
+}
+func (e recordEntry) Infof(string, ...interface{})    { e.record() }
+func (e recordEntry) Warningf(string, ...interface{}) { e.record() }
+func (e recordEntry) Errorf(string, ...interface{})   { e.record() }
+func (e recordEntry) record() {
+	e.mutex.Lock()
+	*e.routes = append(*e.routes, e.route)
+	e.mutex.Unlock()
+}
+
+type subtractParams struct {
+	Minuend    int `json:"minuend"`
+	Subtrahend int `json:"subtrahend"`
+}
+
+func jsonrpcTest(t *testing.T, body string) (*httptest.ResponseRecorder, []string) {
+	var routes []string
+	entry := recordEntry{mutex: &sync.Mutex{}, routes: &routes}
+
+	s := NewServer(Recoverer(entry), Logger(entry))
+	s.Register("subtract", func(ctx context.Context, p []int) (int, error) {
+		if len(p) != 2 {
+			return 0, NewError(CodeInvalidParams, "Invalid params", nil)
+		}
+		return p[0] - p[1], nil
+	})
+	s.Register("subtract_named", func(ctx context.Context, p subtractParams) (int, error) {
+		return p.Minuend - p.Subtrahend, nil
+	})
+	s.Register("notify", func(ctx context.Context) error {
+		return nil
+	})
+	s.Register("fail", func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+	s.Register("panic", func(ctx context.Context) error {
+		panic("boom")
+	})
+
+	router := httprouter.New()
+	s.Mount(zin.NewGroup("/"), router.POST, "/rpc")
+
+	req, err := http.NewRequest("POST", "/rpc", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	return rec, routes
+}
+
+func assertJSON(t *testing.T, got string, expected string) {
+	var g, e interface{}
+	if err := json.Unmarshal([]byte(got), &g); err != nil {
+		t.Fatalf("invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(expected), &e); err != nil {
+		t.Fatal(err)
+	}
+	gb, _ := json.Marshal(g)
+	eb, _ := json.Marshal(e)
+	if string(gb) != string(eb) {
+		t.Fatalf("expected %s got %s", eb, gb)
+	}
+}
+
+func TestCall(t *testing.T) {
+	rec, routes := jsonrpcTest(t, `{"jsonrpc": "2.0", "method": "subtract_named", "params": {"subtrahend": 23, "minuend": 42}, "id": 3}`)
+
+	assertJSON(t, rec.Body.String(), `{"jsonrpc": "2.0", "result": 19, "id": 3}`)
+
+	if len(routes) != 1 || routes[0] != "subtract_named" {
+		t.Fatalf("expected method logged as route got %v", routes)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	cases := map[string]string{
+		`{"jsonrpc": "2.0", "method": "foobar", "id": "1"}`:     `{"jsonrpc": "2.0", "error": {"code": -32601, "message": "Method not found"}, "id": "1"}`,
+		`{"jsonrpc": "2.0", "method": "foobar, "params": "bar"`: `{"jsonrpc": "2.0", "error": {"code": -32700, "message": "Parse error"}, "id": null}`,
+		`{"jsonrpc": "2.0", "method": 1, "params": "bar"}`:      `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`,
+		`[]`: `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`,
+		`{"jsonrpc": "2.0", "method": "subtract", "params": [1], "id": 2}`: `{"jsonrpc": "2.0", "error": {"code": -32602, "message": "Invalid params"}, "id": 2}`,
+		`{"jsonrpc": "2.0", "method": "fail", "id": null}`:                 `{"jsonrpc": "2.0", "error": {"code": -32603, "message": "Internal error"}, "id": null}`,
+		`{"jsonrpc": "2.0", "method": "panic", "id": 3}`:                   `{"jsonrpc": "2.0", "error": {"code": -32603, "message": "Internal error"}, "id": 3}`,
+	}
+
+	for body, expected := range cases {
+		rec, _ := jsonrpcTest(t, body)
+		assertJSON(t, rec.Body.String(), expected)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	rec, routes := jsonrpcTest(t, `[
+		{"jsonrpc": "2.0", "method": "subtract", "params": [42, 23], "id": "1"},
+		{"jsonrpc": "2.0", "method": "notify"},
+		1,
+		{"jsonrpc": "2.0", "method": "subtract", "params": [23, 42], "id": "2"}
+	]`)
+
+	assertJSON(t, rec.Body.String(), `[
+		{"jsonrpc": "2.0", "result": 19, "id": "1"},
+		{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null},
+		{"jsonrpc": "2.0", "result": -19, "id": "2"}
+	]`)
+
+	if len(routes) != 3 {
+		t.Fatalf("expected 3 calls logged got %v", routes)
+	}
+}
+
+func TestBatchPanic(t *testing.T) {
+	s := NewServer()
+	s.BatchConcurrency = 2
+	s.Register("ok", func(ctx context.Context) (int, error) {
+		return 1, nil
+	})
+	s.Register("panic", func(ctx context.Context) error {
+		panic("boom")
+	})
+
+	router := httprouter.New()
+	s.Mount(zin.NewGroup("/"), router.POST, "/rpc")
+
+	// panics are replied as internal errors even without Recoverer
+	batch := `[{"jsonrpc": "2.0", "method": "panic", "id": 1}, {"jsonrpc": "2.0", "method": "panic"}`
+	expected := `[{"jsonrpc": "2.0", "error": {"code": -32603, "message": "Internal error"}, "id": 1}`
+	for i := 2; i < 10; i++ {
+		batch += fmt.Sprintf(`, {"jsonrpc": "2.0", "method": "ok", "id": %d}`, i)
+		expected += fmt.Sprintf(`, {"jsonrpc": "2.0", "result": 1, "id": %d}`, i)
+	}
+
+	req := httptest.NewRequest("POST", "/rpc", strings.NewReader(batch+"]"))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assertJSON(t, rec.Body.String(), expected+"]")
+}
+
+func TestMaxBodySize(t *testing.T) {
+	s := NewServer()
+	s.MaxBodySize = 64
+	s.Register("echo", func(ctx context.Context, p []string) ([]string, error) {
+		return p, nil
+	})
+
+	router := httprouter.New()
+	s.Mount(zin.NewGroup("/"), router.POST, "/rpc")
+
+	body := `{"jsonrpc": "2.0", "method": "echo", "params": ["` + strings.Repeat("a", 64) + `"], "id": 1}`
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/rpc", strings.NewReader(body)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 got %d", rec.Code)
+	}
+
+	body = `{"jsonrpc": "2.0", "method": "echo", "params": ["a"], "id": 1}`
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/rpc", strings.NewReader(body)))
+	assertJSON(t, rec.Body.String(), `{"jsonrpc": "2.0", "result": ["a"], "id": 1}`)
+}
+
+func TestNotificationsOnly(t *testing.T) {
+	rec, _ := jsonrpcTest(t, `[{"jsonrpc": "2.0", "method": "notify"}, {"jsonrpc": "2.0", "method": "notify"}]`)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 got %d", rec.Code)
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("expected empty body got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,52 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/rayark/zin/v2/middleware"
+)
+
+// Logger logs each method call with the method name as the route, internal
+// errors are logged as errors and other error objects as warnings
+func Logger(entry middleware.LogEntry) MethodMiddleware {
+	return func(h MethodHandler) MethodHandler {
+		return func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+			t1 := time.Now()
+			result, err := h(ctx, params)
+			t2 := time.Now()
+
+			method, _ := MethodFromContext(ctx)
+			msec := t2.Sub(t1).Milliseconds()
+
+			e := entry.
+				WithField("route", method).
+				WithField("msec", msec)
+
+			if err == nil {
+				e.Infof("rpc %s", method)
+				return result, err
+			}
+
+			rpcErr, ok := err.(*Error)
+			if !ok || rpcErr.Code == CodeInternalError {
+				e.WithField("error", err.Error()).Errorf("rpc %s failed", method)
+			} else {
+				e.WithField("code", rpcErr.Code).Warningf("rpc %s: %s", method, rpcErr.Message)
+			}
+			return result, err
+		}
+	}
+}
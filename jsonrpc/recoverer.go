@@ -0,0 +1,43 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/rayark/zin/v2/middleware"
+)
+
+// Recoverer logs panics of method calls with their call stack and returns
+// them as internal errors, which Logger outside it logs as failed calls.
+// Without it panics are still replied as internal errors, but not logged.
+func Recoverer(entry middleware.LogEntry) MethodMiddleware {
+	return func(h MethodHandler) MethodHandler {
+		return func(ctx context.Context, params json.RawMessage) (result interface{}, err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					method, _ := MethodFromContext(ctx)
+					entry.
+						WithField("route", method).
+						WithField("call_stack", string(debug.Stack())).
+						Errorf("panic: %+v", p)
+					result, err = nil, fmt.Errorf("jsonrpc: panic: %v", p)
+				}
+			}()
+
+			return h(ctx, params)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// DefaultBufferMemoryLimit is the size of bodies BufferWriter keeps in memory
+// unless configured
+const DefaultBufferMemoryLimit = 1 << 20
+
+// BufferWriter buffers the status, the headers and the body of a response,
+// so that middlewares can inspect and rewrite them after the handler before
+// Commit writes them in order. Bodies past the memory limit spill to a temp
+// file. Informational 1xx responses are written through.
+//
+// Handlers see a copy of the headers of the underlying writer; Commit
+// replaces them by the buffered ones. Close releases the temp file of
+// responses never committed. BufferWriter does not implement http.Flusher.
+type BufferWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	status      int
+	memoryLimit int64
+
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+
+	hijacked  bool
+	committed bool
+}
+
+// NewBufferWriter returns a BufferWriter of w keeping up to memoryLimit bytes
+// of the body in memory, DefaultBufferMemoryLimit if not positive
+func NewBufferWriter(w http.ResponseWriter, memoryLimit int64) *BufferWriter {
+	if memoryLimit <= 0 {
+		memoryLimit = DefaultBufferMemoryLimit
+	}
+	return &BufferWriter{
+		w:           w,
+		header:      w.Header().Clone(),
+		memoryLimit: memoryLimit,
+	}
+}
+
+func (w *BufferWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *BufferWriter) WriteHeader(status int) {
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		copyHeader(w.w.Header(), w.header)
+		w.w.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Status returns the buffered status, 200 if none was written
+func (w *BufferWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// SetStatus replaces the buffered status
+func (w *BufferWriter) SetStatus(status int) {
+	w.status = status
+}
+
+func (w *BufferWriter) Write(b []byte) (int, error) {
+	if w.hijacked {
+		return 0, http.ErrHijacked
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if w.file == nil && int64(w.buf.Len()+len(b)) > w.memoryLimit {
+		if err := w.spill(); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if w.file != nil {
+		n, err = w.file.Write(b)
+	} else {
+		n, err = w.buf.Write(b)
+	}
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *BufferWriter) spill() error {
+	f, err := ioutil.TempFile("", "zin-buffer-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(w.buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	w.file = f
+	w.buf.Reset()
+	return nil
+}
+
+// Len returns the size of the buffered body
+func (w *BufferWriter) Len() int64 {
+	return w.size
+}
+
+// Body returns a reader of the buffered body from its start
+func (w *BufferWriter) Body() (io.Reader, error) {
+	if w.file == nil {
+		return bytes.NewReader(w.buf.Bytes()), nil
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.LimitReader(w.file, w.size), nil
+}
+
+// Bytes returns the buffered body, read back from the temp file if spilled
+func (w *BufferWriter) Bytes() ([]byte, error) {
+	if w.file == nil {
+		return w.buf.Bytes(), nil
+	}
+	body, err := w.Body()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(body)
+}
+
+// ResetBody discards the buffered body, to be written again
+func (w *BufferWriter) ResetBody() error {
+	w.buf.Reset()
+	w.size = 0
+	if w.file == nil {
+		return nil
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := w.file.Seek(0, io.SeekStart)
+	return err
+}
+
+// Hijack passes the connection through, the buffered response is dropped
+// since the connection no longer speaks HTTP
+func (w *BufferWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(w.w)
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// ReadFrom buffers src
+func (w *BufferWriter) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(writerOnly{w}, src)
+}
+
+func (w *BufferWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.w, target, opts)
+}
+
+// Hijacked reports whether the handler hijacked the connection
+func (w *BufferWriter) Hijacked() bool {
+	return w.hijacked
+}
+
+// Commit writes the headers, the status and the body to the underlying
+// writer, with the Content-Length of the buffered body unless set, then
+// releases the buffer. It does nothing once committed or hijacked.
+func (w *BufferWriter) Commit() error {
+	defer w.Close()
+	if w.committed || w.hijacked {
+		return nil
+	}
+	w.committed = true
+
+	if w.size > 0 && bodyAllowed(w.Status()) && w.header.Get("Content-Length") == "" && w.header.Get("Transfer-Encoding") == "" {
+		w.header.Set("Content-Length", strconv.FormatInt(w.size, 10))
+	}
+	copyHeader(w.w.Header(), w.header)
+	w.w.WriteHeader(w.Status())
+
+	body, err := w.Body()
+	if err != nil {
+		return err
+	}
+	// a spilled body is sent by sendfile where supported
+	_, err = io.Copy(w.w, body)
+	return err
+}
+
+// Close releases the temp file of the body
+func (w *BufferWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	name := w.file.Name()
+	err := w.file.Close()
+	os.Remove(name)
+	w.file = nil
+	return err
+}
+
+// copyHeader replaces the header dst by src
+func copyHeader(dst, src http.Header) {
+	for k := range dst {
+		if _, ok := src[k]; !ok {
+			delete(dst, k)
+		}
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+}
@@ -0,0 +1,123 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	. "github.com/rayark/zin/v2/middleware"
+)
+
+func TestBufferWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Outer", "1")
+
+	bw := NewBufferWriter(rec, 0)
+	bw.Header().Set("Content-Type", "text/plain")
+	bw.WriteHeader(http.StatusCreated)
+	bw.Write([]byte("hello"))
+	bw.Header().Del("X-Outer")
+
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.HeaderMap.Get("Content-Type") != "" {
+		t.Fatal("expected nothing written before commit")
+	}
+
+	// headers and status are still rewritable after WriteHeader
+	bw.Header().Set("X-Late", "1")
+	bw.SetStatus(http.StatusAccepted)
+	if err := bw.ResetBody(); err != nil {
+		t.Fatal(err)
+	}
+	bw.Write([]byte("rewritten"))
+
+	if err := bw.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("expected 202 got %d", rec.Code)
+	}
+	if rec.HeaderMap.Get("X-Late") != "1" || rec.HeaderMap.Get("Content-Type") != "text/plain" {
+		t.Errorf("expected buffered headers got %v", rec.HeaderMap)
+	}
+	if rec.HeaderMap.Get("X-Outer") != "" {
+		t.Errorf("expected deleted header removed got '%s'", rec.HeaderMap.Get("X-Outer"))
+	}
+	if rec.Body.String() != "rewritten" {
+		t.Errorf("expected body 'rewritten' got '%s'", rec.Body.String())
+	}
+	if cl := rec.HeaderMap.Get("Content-Length"); cl != "9" {
+		t.Errorf("expected Content-Length 9 got '%s'", cl)
+	}
+}
+
+func TestBufferWriterContentLength(t *testing.T) {
+	signers := map[string]zin.Middleware{
+		"hmac":      HMACSHA1Signer(hmacHeaderKey, "", []byte(secretString)),
+		"digest":    ContentDigest(),
+		"signature": MessageSignatureSigner(MessageSignatureConfig{Key: SignatureKey{ID: "k", Algorithm: SignatureHMACSHA256, Secret: []byte(secretString)}}),
+	}
+
+	for name, m := range signers {
+		router := httprouter.New()
+		grp := zin.NewGroup("/", m)
+		grp.R(router.GET, "/", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hello"))
+		})
+		srv := httptest.NewServer(router)
+
+		// buffered responses keep their length rather than being chunked
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		srv.Close()
+		if resp.ContentLength != 5 || len(resp.TransferEncoding) != 0 {
+			t.Errorf("%s: expected Content-Length 5 got %d %v", name, resp.ContentLength, resp.TransferEncoding)
+		}
+	}
+}
+
+func TestBufferWriterSpill(t *testing.T) {
+	rec := httptest.NewRecorder()
+	bw := NewBufferWriter(rec, 16)
+
+	chunk := []byte("0123456789")
+	for i := 0; i < 10; i++ {
+		bw.Write(chunk)
+	}
+	if bw.Len() != 100 {
+		t.Errorf("expected length 100 got %d", bw.Len())
+	}
+
+	body, err := bw.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bytes.Repeat(chunk, 10)
+	if !bytes.Equal(body, expected) {
+		t.Errorf("unexpected spilled body '%s'", body)
+	}
+
+	if err := bw.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), expected) {
+		t.Errorf("unexpected committed body '%s'", rec.Body.String())
+	}
+}
@@ -12,39 +12,309 @@
 package middleware
 
 import (
-	"compress/gzip"
+	"bufio"
 	"io"
+	"mime"
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-type gzipResponseWriter struct {
-	io.Writer
+type CompressorConfig struct {
+	// MinSize is the body size from which responses are compressed, smaller
+	// bodies are sent as is
+	MinSize int
+
+	// ContentTypes are the media types eligible for compression. "text/*"
+	// matches a whole type and "application/*+json" a structured syntax
+	// suffix.
+	ContentTypes []string
+
+	// Encoders are the supported content-codings in order of server
+	// preference, which decides between codings of equal q-values. gzip and
+	// deflate are used if empty.
+	Encoders []Encoder
+}
+
+var DefaultCompressorConfig = CompressorConfig{
+	MinSize: 1400,
+	ContentTypes: []string{
+		"text/*",
+		"application/json",
+		"application/*+json",
+		"application/javascript",
+		"application/xml",
+		"application/*+xml",
+		"application/wasm",
+		"image/svg+xml",
+	},
+}
+
+// compressResponseWriter buffers the beginning of the body until it is
+// large enough to decide whether to compress, then commits the header and
+// either compresses or passes the body through
+type compressResponseWriter struct {
 	http.ResponseWriter
+	config *CompressorConfig
+	pool   *encoderPool
+
+	status   int
+	buf      []byte
+	decided  bool
+	enc      EncoderWriter
+	hijacked bool
+}
+
+func (w *compressResponseWriter) WriteHeader(s int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	if s >= 100 && s <= 199 && s != http.StatusSwitchingProtocols {
+		// informational responses do not commit the final header
+		w.ResponseWriter.WriteHeader(s)
+		return
+	}
+	w.status = s
+	if !bodyAllowed(s) {
+		w.decide(false)
+	}
+}
+
+func (w *compressResponseWriter) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, http.ErrHijacked
+	}
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.config.MinSize {
+			return len(p), nil
+		}
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// decide commits the header and the buffered body. When flushing, the
+// minimum size is ignored since a streaming body is expected to grow.
+func (w *compressResponseWriter) decide(flushing bool) error {
+	w.decided = true
+
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	header := w.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 && header.Get("Content-Encoding") == "" {
+		// sniff before compressing, net/http would sniff the compressed bytes
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	if w.shouldCompress(status, flushing) {
+		if enc, err := w.pool.get(w.ResponseWriter); err == nil {
+			header.Set("Content-Encoding", w.pool.encoder.Name())
+			header.Del("Content-Length")
+			w.enc = enc
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
 }
 
-func (w gzipResponseWriter) Write(p []byte) (int, error) {
-	return w.Writer.Write(p)
+func (w *compressResponseWriter) shouldCompress(status int, flushing bool) bool {
+	if !bodyAllowed(status) || status == http.StatusPartialContent {
+		return false
+	}
+	if !flushing && len(w.buf) < w.config.MinSize {
+		return false
+	}
+
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	return matchContentType(header.Get("Content-Type"), w.config.ContentTypes)
+}
+
+// close commits what is still buffered and finishes the compressed stream
+func (w *compressResponseWriter) close() {
+	if w.hijacked {
+		return
+	}
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.pool.put(w.enc)
+		w.enc = nil
+	}
 }
 
-// Compressor compress the response body if the header of request
-// contained `Accept-Encoding`
+// Flush commits the header, flushes the pending compressed data then the
+// underlying writer
+func (w *compressResponseWriter) Flush() {
+	if w.hijacked {
+		return
+	}
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ReadFrom fills the buffer to decide, then hands the rest of src to the
+// underlying io.ReaderFrom when passing through, so sendfile still applies
+func (w *compressResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	var n int64
+	if !w.decided && w.config.MinSize > len(w.buf) {
+		m, err := io.Copy(writerOnly{w}, io.LimitReader(src, int64(w.config.MinSize-len(w.buf))))
+		n += m
+		if err != nil || !w.decided {
+			return n, err
+		}
+	}
+
+	if w.decided && w.enc == nil && !w.hijacked {
+		if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+			m, err := rf.ReadFrom(src)
+			return n + m, err
+		}
+	}
+
+	m, err := io.Copy(writerOnly{w}, src)
+	return n + m, err
+}
+
+// Hijack steps the writer aside, nothing is written or flushed afterwards
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(w.ResponseWriter)
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (w *compressResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// writerOnly hides ReadFrom from io.Copy
+type writerOnly struct {
+	io.Writer
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+func matchContentType(contentType string, patterns []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		switch {
+		case pattern == mediaType:
+			return true
+		case strings.HasSuffix(pattern, "/*"):
+			if strings.HasPrefix(mediaType, pattern[:len(pattern)-1]) {
+				return true
+			}
+		case strings.Contains(pattern, "/*+"):
+			i := strings.Index(pattern, "*")
+			if strings.HasPrefix(mediaType, pattern[:i]) && strings.HasSuffix(mediaType, pattern[i+1:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var defaultCompressor = CompressorWithConfig(DefaultCompressorConfig)
+
+// Compressor compress the response body with the coding negotiated from the
+// `Accept-Encoding` of request, using DefaultCompressorConfig
 func Compressor(h httprouter.Handle) httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		switch r.Header.Get("Accept-Encoding") {
-		case "gzip":
-			w.Header().Set("Content-Encoding", "gzip")
-			gzw := gzip.NewWriter(w)
-			defer gzw.Close()
-			gzRespWriter := gzipResponseWriter{Writer: gzw, ResponseWriter: w}
-			h(gzRespWriter, r, p)
-		case "deflate":
-			// Currently only support "gzip" encoding, "deflate" is not
-			// implemented yet.
-			h(w, r, p)
-		default:
-			h(w, r, p)
+	return defaultCompressor(h)
+}
+
+// CompressorWithConfig returns a Compressor which buffers the beginning of
+// the body to decide whether to compress, by the size, status and
+// Content-Type of the response. Responses already carrying a
+// Content-Encoding are passed through. Requests refusing every coding
+// including identity are replied with 406. Upgrade requests are passed
+// through untouched.
+func CompressorWithConfig(config CompressorConfig) middleware {
+	encoders := config.Encoders
+	if len(encoders) == 0 {
+		encoders = defaultEncoders
+	}
+
+	names := make([]string, len(encoders))
+	pools := make(map[string]*encoderPool, len(encoders))
+	for i, e := range encoders {
+		names[i] = strings.ToLower(e.Name())
+		pools[names[i]] = newEncoderPool(e)
+	}
+
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			if IsUpgradeRequest(r) {
+				h(w, r, p)
+				return
+			}
+
+			addVary(w.Header(), "Accept-Encoding")
+
+			coding, ok := negotiateEncoding(r.Header, names)
+			if !ok {
+				http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+				return
+			}
+
+			if coding == identityEncoding || r.Method == http.MethodHead {
+				h(w, r, p)
+				return
+			}
+
+			cw := &compressResponseWriter{ResponseWriter: w, config: &config, pool: pools[coding]}
+			defer cw.close()
+			h(WrapWriter(w, cw), r, p)
 		}
 	}
 }
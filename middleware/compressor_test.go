@@ -12,11 +12,16 @@
 package middleware_test
 
 import (
+	"bufio"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/julienschmidt/httprouter"
@@ -25,6 +30,12 @@ import (
 )
 
 func middlewareCompressorTest(t *testing.T, reqHeaders map[string]string, respBody string) *httptest.ResponseRecorder {
+	return middlewareCompressorHandleTest(t, reqHeaders, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Write([]byte(respBody))
+	})
+}
+
+func middlewareCompressorHandleTest(t *testing.T, reqHeaders map[string]string, handle httprouter.Handle) *httptest.ResponseRecorder {
 	path := "/gzip"
 
 	req, err := http.NewRequest("GET", path, nil)
@@ -37,9 +48,7 @@ func middlewareCompressorTest(t *testing.T, reqHeaders map[string]string, respBo
 
 	router := httprouter.New()
 	grp := zin.NewGroup("/", Compressor)
-	grp.R(router.GET, path, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		w.Write([]byte(respBody))
-	})
+	grp.R(router.GET, path, handle)
 
 	rec := httptest.NewRecorder()
 	router.ServeHTTP(rec, req)
@@ -51,7 +60,7 @@ func TestWithGzip(t *testing.T) {
 	reqHeaders := map[string]string{
 		"Accept-Encoding": "gzip",
 	}
-	respBody := "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	respBody := strings.Repeat("a", 2000)
 
 	rec := middlewareCompressorTest(t, reqHeaders, respBody)
 
@@ -84,7 +93,7 @@ func TestWithGzip(t *testing.T) {
 
 func TestWithoutGzip(t *testing.T) {
 	reqHeaders := map[string]string{}
-	respBody := "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	respBody := strings.Repeat("a", 2000)
 
 	rec := middlewareCompressorTest(t, reqHeaders, respBody)
 
@@ -117,18 +126,50 @@ func TestNoBody(t *testing.T) {
 		t.Fatalf("expected 200 got '%d'", rec.Code)
 	}
 
+	if rec.HeaderMap.Get("Content-Encoding") != "" {
+		t.Fatalf("expected Content-Encoding: '' got '%s'", rec.HeaderMap.Get("Content-Encoding"))
+	}
+
+	if rec.Body.Len() != 0 {
+		t.Fatalf(`expected empty body got "%s"`, rec.Body.String())
+	}
+}
+
+func TestBrowserAcceptEncoding(t *testing.T) {
+	reqHeaders := map[string]string{
+		"Accept-Encoding": "gzip, deflate, br",
+	}
+	respBody := strings.Repeat("a", 2000)
+
+	rec := middlewareCompressorTest(t, reqHeaders, respBody)
+
 	if rec.HeaderMap.Get("Content-Encoding") != "gzip" {
 		t.Fatalf("expected Content-Encoding: gzip got '%s'", rec.HeaderMap.Get("Content-Encoding"))
 	}
 
-	respBodySize := len(rec.Body.Bytes())
+	if rec.HeaderMap.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected Vary: Accept-Encoding got '%s'", rec.HeaderMap.Get("Vary"))
+	}
+}
 
-	gzr, err := gzip.NewReader(rec.Body)
+func TestWithDeflate(t *testing.T) {
+	reqHeaders := map[string]string{
+		"Accept-Encoding": "gzip;q=0.5, deflate",
+	}
+	respBody := strings.Repeat("a", 2000)
+
+	rec := middlewareCompressorTest(t, reqHeaders, respBody)
+
+	if rec.HeaderMap.Get("Content-Encoding") != "deflate" {
+		t.Fatalf("expected Content-Encoding: deflate got '%s'", rec.HeaderMap.Get("Content-Encoding"))
+	}
+
+	zr, err := zlib.NewReader(rec.Body)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	body, err := ioutil.ReadAll(gzr)
+	body, err := ioutil.ReadAll(zr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -136,6 +177,292 @@ func TestNoBody(t *testing.T) {
 	if string(body) != respBody {
 		t.Fatalf(`expected "%s" got "%s"`, respBody, string(body))
 	}
+}
 
-	log.Printf("Code: %d with Content-size(trans/orig): (%d/%d)", rec.Code, respBodySize, len(body))
+func TestAcceptEncodingNegotiation(t *testing.T) {
+	respBody := strings.Repeat("a", 2000)
+
+	cases := []struct {
+		acceptEncoding string
+		code           int
+		encoding       string
+	}{
+		{"br", http.StatusOK, ""},
+		{"gzip;q=0", http.StatusOK, ""},
+		{"*", http.StatusOK, "gzip"},
+		{"*;q=0.5, gzip;q=0", http.StatusOK, "deflate"},
+		{"identity;q=1, gzip;q=0.5", http.StatusOK, ""},
+		{"GZIP;Q=0.8", http.StatusOK, "gzip"},
+		{"identity;q=0, deflate", http.StatusOK, "deflate"},
+		{"br, identity;q=0", http.StatusNotAcceptable, ""},
+		{"*;q=0", http.StatusNotAcceptable, ""},
+	}
+
+	for _, c := range cases {
+		rec := middlewareCompressorTest(t, map[string]string{"Accept-Encoding": c.acceptEncoding}, respBody)
+
+		if rec.Code != c.code {
+			t.Fatalf("%s: expected %d got '%d'", c.acceptEncoding, c.code, rec.Code)
+		}
+
+		if rec.HeaderMap.Get("Content-Encoding") != c.encoding {
+			t.Fatalf("%s: expected Content-Encoding: '%s' got '%s'", c.acceptEncoding, c.encoding, rec.HeaderMap.Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestCompressDecision(t *testing.T) {
+	reqHeaders := map[string]string{
+		"Accept-Encoding": "gzip",
+	}
+	large := strings.Repeat("{}", 1000)
+
+	cases := []struct {
+		name     string
+		handle   httprouter.Handle
+		code     int
+		encoding string
+	}{
+		{"tiny json", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("{}"))
+		}, http.StatusOK, ""},
+		{"large json in pieces", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("Content-Length", "2000")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(large[:1000]))
+			w.Write([]byte(large[1000:]))
+		}, http.StatusCreated, "gzip"},
+		{"problem json", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(large))
+		}, http.StatusBadRequest, "gzip"},
+		{"image", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(large))
+		}, http.StatusOK, ""},
+		{"already encoded", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte(large))
+		}, http.StatusOK, "br"},
+		{"no content", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.WriteHeader(http.StatusNoContent)
+		}, http.StatusNoContent, ""},
+		{"not modified", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.WriteHeader(http.StatusNotModified)
+		}, http.StatusNotModified, ""},
+	}
+
+	for _, c := range cases {
+		rec := middlewareCompressorHandleTest(t, reqHeaders, c.handle)
+
+		if rec.Code != c.code {
+			t.Fatalf("%s: expected %d got '%d'", c.name, c.code, rec.Code)
+		}
+
+		if rec.HeaderMap.Get("Content-Encoding") != c.encoding {
+			t.Fatalf("%s: expected Content-Encoding: '%s' got '%s'", c.name, c.encoding, rec.HeaderMap.Get("Content-Encoding"))
+		}
+
+		if c.encoding == "gzip" && rec.HeaderMap.Get("Content-Length") != "" {
+			t.Fatalf("%s: expected Content-Length dropped got '%s'", c.name, rec.HeaderMap.Get("Content-Length"))
+		}
+
+		if c.encoding == "gzip" {
+			gzr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(gzr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != large {
+				t.Fatalf("%s: unexpected body", c.name)
+			}
+		}
+	}
+}
+
+func TestEncoderPreference(t *testing.T) {
+	respBody := strings.Repeat("a", 2000)
+
+	config := DefaultCompressorConfig
+	config.Encoders = []Encoder{
+		NewEncoder("x-raw-deflate", flate.BestSpeed, func(w io.Writer, level int) (EncoderWriter, error) {
+			return flate.NewWriter(w, level)
+		}),
+		DeflateEncoder(flate.BestCompression),
+		GzipEncoder(gzip.BestSpeed),
+	}
+
+	cases := map[string]string{
+		"gzip, deflate":                   "deflate",
+		"gzip, deflate, x-raw-deflate":    "x-raw-deflate",
+		"gzip, deflate;q=0.9":             "gzip",
+		"x-raw-deflate;q=0.1, gzip;q=0.2": "gzip",
+	}
+
+	for acceptEncoding, expected := range cases {
+		req, err := http.NewRequest("GET", "/gzip", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+
+		router := httprouter.New()
+		grp := zin.NewGroup("/", CompressorWithConfig(config))
+		grp.R(router.GET, "/gzip", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.Write([]byte(respBody))
+		})
+
+		// the second response reuses the pooled writer
+		for i := 0; i < 2; i++ {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.HeaderMap.Get("Content-Encoding") != expected {
+				t.Fatalf("%s: expected Content-Encoding: %s got '%s'", acceptEncoding, expected, rec.HeaderMap.Get("Content-Encoding"))
+			}
+		}
+	}
+}
+
+func BenchmarkCompressor(b *testing.B) {
+	respBody := []byte(strings.Repeat(`{"id":1,"name":"zin"},`, 1000))
+
+	router := httprouter.New()
+	grp := zin.NewGroup("/", Compressor)
+	grp.R(router.GET, "/gzip", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(respBody)
+	})
+
+	req, err := http.NewRequest("GET", "/gzip", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func TestCompressorStreaming(t *testing.T) {
+	release := make(chan struct{})
+
+	router := httprouter.New()
+	grp := zin.NewGroup("/", Compressor)
+	grp.R(router.GET, "/stream", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("progress 1\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("progress 2\n"))
+	})
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL+"/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected Content-Encoding: gzip got '%s'", resp.Header.Get("Content-Encoding"))
+	}
+	if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("expected chunked response got %v", resp.TransferEncoding)
+	}
+
+	gzr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(gzr)
+
+	// the first chunk must arrive while the handler is still blocked
+	line, err := br.ReadString('\n')
+	if err != nil || line != "progress 1\n" {
+		t.Fatalf("expected first progress got '%s' %v", line, err)
+	}
+
+	close(release)
+
+	rest, err := ioutil.ReadAll(br)
+	if err != nil || string(rest) != "progress 2\n" {
+		t.Fatalf("expected second progress got '%s' %v", rest, err)
+	}
+}
+
+// readerFromRecorder is a recorder whose writer supports io.ReaderFrom like
+// the one of net/http
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (r *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func TestCompressorReadFrom(t *testing.T) {
+	content := strings.Repeat("text ", 1000)
+	handle := func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if _, ok := w.(io.ReaderFrom); !ok {
+			t.Fatalf("expected io.ReaderFrom preserved")
+		}
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		// hide WriteTo so that io.Copy goes through ReadFrom
+		io.Copy(w, struct{ io.Reader }{strings.NewReader(content)})
+	}
+
+	rec := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	router := httprouter.New()
+	grp := zin.NewGroup("/", Compressor)
+	grp.R(router.GET, "/gzip", handle)
+
+	req, _ := http.NewRequest("GET", "/gzip?type=text/plain", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(rec, req)
+
+	if rec.HeaderMap.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected Content-Encoding: gzip got '%s'", rec.HeaderMap.Get("Content-Encoding"))
+	}
+
+	gzr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(gzr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != content {
+		t.Fatalf("body inconsistent")
+	}
+
+	// passthrough hands the body to the underlying io.ReaderFrom
+	rec = &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	req, _ = http.NewRequest("GET", "/gzip?type=image/png", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(rec, req)
+	if !rec.readFrom || rec.Body.String() != content {
+		t.Errorf("expected underlying ReadFrom used got %v", rec.readFrom)
+	}
 }
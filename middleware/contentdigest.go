@@ -0,0 +1,121 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const contentDigestHeader = "Content-Digest"
+
+// digestAlgorithms are the Content-Digest algorithms of RFC 9530 considered
+// secure
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// contentDigest returns the Content-Digest value of body for algorithms
+func contentDigest(body io.Reader, algorithms ...string) (string, error) {
+	hashes := make([]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, len(algorithms))
+	for i, alg := range algorithms {
+		hashes[i] = digestAlgorithms[alg]()
+		writers[i] = hashes[i]
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), body); err != nil {
+		return "", err
+	}
+
+	values := make([]string, len(algorithms))
+	for i, alg := range algorithms {
+		values[i] = alg + "=" + serializeSFBareItem(hashes[i].Sum(nil))
+	}
+	return strings.Join(values, ", "), nil
+}
+
+// verifyContentDigest reports whether value holds at least one digest of a
+// known algorithm and all of those match body. Unknown algorithms are ignored.
+func verifyContentDigest(value string, body []byte) bool {
+	members, err := parseSFDictionary(value)
+	if err != nil {
+		return false
+	}
+
+	verified := false
+	for _, m := range members {
+		newHash, ok := digestAlgorithms[m.key]
+		if !ok {
+			continue
+		}
+		digest, ok := m.item.value.([]byte)
+		if m.isList || !ok {
+			return false
+		}
+
+		h := newHash()
+		h.Write(body)
+		if subtle.ConstantTimeCompare(digest, h.Sum(nil)) != 1 {
+			return false
+		}
+		verified = true
+	}
+	return verified
+}
+
+func checkDigestAlgorithms(algorithms []string) {
+	for _, alg := range algorithms {
+		if _, ok := digestAlgorithms[alg]; !ok {
+			panic(fmt.Sprintf("middleware: unsupported digest algorithm %q", alg))
+		}
+	}
+}
+
+// ContentDigest adds the Content-Digest header (RFC 9530) of the response
+// body, by "sha-256" unless algorithms are given. The response is buffered
+// by BufferWriter like HMACSigner does.
+func ContentDigest(algorithms ...string) middleware {
+	if len(algorithms) == 0 {
+		algorithms = []string{"sha-256"}
+	}
+	checkDigestAlgorithms(algorithms)
+
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			bw := NewBufferWriter(w, 0)
+			defer bw.Close()
+			h(WrapWriter(w, bw), r, p)
+
+			body, err := bw.Body()
+			if err == nil {
+				var digest string
+				if digest, err = contentDigest(body, algorithms...); err == nil {
+					bw.Header().Set(contentDigestHeader, digest)
+				}
+			}
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			bw.Commit()
+		}
+	}
+}
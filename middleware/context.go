@@ -20,7 +20,11 @@ import (
 
 type zinContextKey int
 
-const MatchedRoutePathKey zinContextKey = iota
+const (
+	MatchedRoutePathKey zinContextKey = iota
+	MatchedVariantKey
+	ClientIPKey
+)
 
 func AddRouteToContext(route string) middleware {
 	return func(h httprouter.Handle) httprouter.Handle {
@@ -37,3 +41,27 @@ func GetRouteFromContext(ctx context.Context) (string, bool) {
 	route, ok := ctx.Value(MatchedRoutePathKey).(string)
 	return route, ok
 }
+
+// AddVariantToContext stores the name of the variant which serves a split
+// route in the request context
+func AddVariantToContext(variant string) middleware {
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			ctx := r.Context()
+			ctx = context.WithValue(ctx, MatchedVariantKey, variant)
+
+			h(w, r.WithContext(ctx), p)
+		}
+	}
+}
+
+func GetVariantFromContext(ctx context.Context) (string, bool) {
+	variant, ok := ctx.Value(MatchedVariantKey).(string)
+	return variant, ok
+}
+
+// GetClientIPFromContext returns the client IP stored by RealIP
+func GetClientIPFromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(ClientIPKey).(string)
+	return ip, ok
+}
@@ -0,0 +1,103 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type decompressedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decompressedBody) Close() error {
+	var err error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if e := b.closers[i].Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func newDecodingReader(coding string, r io.Reader) (io.ReadCloser, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		return zlib.NewReader(r)
+	}
+	return nil, nil
+}
+
+// Decompressor decompresses request bodies sent with a gzip or deflate
+// `Content-Encoding`, so handlers read the plain body. The decompressed body
+// is limited to maxSize bytes like http.MaxBytesReader. Requests with an
+// unknown coding are replied with 415 and malformed compressed bodies with
+// 400.
+func Decompressor(maxSize int64) middleware {
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			var codings []string
+			for _, v := range r.Header["Content-Encoding"] {
+				for _, coding := range strings.Split(v, ",") {
+					coding = strings.ToLower(strings.TrimSpace(coding))
+					if coding != "" && coding != identityEncoding {
+						codings = append(codings, coding)
+					}
+				}
+			}
+
+			if len(codings) == 0 || r.Body == nil || r.Body == http.NoBody {
+				h(w, r, p)
+				return
+			}
+
+			body := &decompressedBody{closers: []io.Closer{r.Body}}
+			var reader io.Reader = r.Body
+
+			// codings are listed in the order they were applied
+			for i := len(codings) - 1; i >= 0; i-- {
+				dr, err := newDecodingReader(codings[i], reader)
+				if dr == nil && err == nil {
+					body.Close()
+					w.Header().Set("Accept-Encoding", "gzip, deflate")
+					http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+					return
+				}
+				if err != nil {
+					body.Close()
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+				body.closers = append(body.closers, dr)
+				reader = dr
+			}
+			body.Reader = reader
+
+			r2 := r.Clone(r.Context())
+			r2.Header.Del("Content-Encoding")
+			r2.Header.Del("Content-Length")
+			r2.ContentLength = -1
+			r2.Body = http.MaxBytesReader(w, body, maxSize)
+
+			h(w, r2, p)
+		}
+	}
+}
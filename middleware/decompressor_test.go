@@ -0,0 +1,97 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	. "github.com/rayark/zin/v2/middleware"
+)
+
+func middlewareDecompressorTest(t *testing.T, encoding string, body []byte, maxSize int64) *httptest.ResponseRecorder {
+	path := "/upload"
+
+	req, err := http.NewRequest("POST", path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Encoding", encoding)
+
+	router := httprouter.New()
+	grp := zin.NewGroup("/", Decompressor(maxSize))
+	grp.R(router.POST, path, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if r.Header.Get("Content-Encoding") != "" || r.Header.Get("Content-Length") != "" || r.ContentLength != -1 {
+			t.Fatalf("expected Content-Encoding and Content-Length removed")
+		}
+
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.Write(b)
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestDecompressor(t *testing.T) {
+	content := strings.Repeat("save data ", 100)
+
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write([]byte(content))
+	gzw.Close()
+
+	var zl bytes.Buffer
+	zw := zlib.NewWriter(&zl)
+	zw.Write([]byte(content))
+	zw.Close()
+
+	for encoding, body := range map[string][]byte{"gzip": gz.Bytes(), "deflate": zl.Bytes()} {
+		rec := middlewareDecompressorTest(t, encoding, body, 1<<20)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200 got '%d'", encoding, rec.Code)
+		}
+		if rec.Body.String() != content {
+			t.Fatalf("%s: body inconsistent", encoding)
+		}
+	}
+
+	rec := middlewareDecompressorTest(t, "gzip", gz.Bytes(), 100)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 got '%d'", rec.Code)
+	}
+
+	rec = middlewareDecompressorTest(t, "br", gz.Bytes(), 1<<20)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415 got '%d'", rec.Code)
+	}
+
+	rec = middlewareDecompressorTest(t, "gzip", []byte(content), 1<<20)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 got '%d'", rec.Code)
+	}
+}
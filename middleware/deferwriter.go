@@ -12,13 +12,21 @@
 package middleware
 
 import (
+	"bufio"
 	"bytes"
+	"io"
+	"net"
 	"net/http"
 )
 
+// DeferWriter buffers the body of a response until WriteAll.
+//
+// Deprecated: the status and headers written by the handler bypass the
+// buffer, use BufferWriter instead.
 type DeferWriter struct {
 	http.ResponseWriter
-	buf *bytes.Buffer
+	buf      *bytes.Buffer
+	hijacked bool
 }
 
 func NewDeferWriter(w http.ResponseWriter) *DeferWriter {
@@ -37,5 +45,26 @@ func (w *DeferWriter) Write(b []byte) (int, error) {
 }
 
 func (w *DeferWriter) WriteAll() {
+	if w.hijacked {
+		return
+	}
 	w.ResponseWriter.Write(w.buf.Bytes())
 }
+
+// Hijack passes the connection through, the deferred body is dropped since
+// the connection no longer speaks HTTP
+func (w *DeferWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(w.ResponseWriter)
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (w *DeferWriter) ReadFrom(src io.Reader) (int64, error) {
+	return w.buf.ReadFrom(src)
+}
+
+func (w *DeferWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
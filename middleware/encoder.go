@@ -0,0 +1,114 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// EncoderWriter is a compressing writer which can be reused through Reset,
+// as the writers of compress/gzip and most zstd and brotli implementations
+type EncoderWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Encoder produces the writers of a content-coding for Compressor
+type Encoder interface {
+	// Name is the content-coding token, e.g. "gzip"
+	Name() string
+	// Level is the compression level passed to NewWriter
+	Level() int
+	NewWriter(w io.Writer, level int) (EncoderWriter, error)
+}
+
+type encoder struct {
+	name    string
+	level   int
+	factory func(w io.Writer, level int) (EncoderWriter, error)
+}
+
+func (e *encoder) Name() string {
+	return e.name
+}
+
+func (e *encoder) Level() int {
+	return e.level
+}
+
+func (e *encoder) NewWriter(w io.Writer, level int) (EncoderWriter, error) {
+	return e.factory(w, level)
+}
+
+// NewEncoder returns an Encoder of the content-coding name creating its
+// writers with factory
+func NewEncoder(name string, level int, factory func(w io.Writer, level int) (EncoderWriter, error)) Encoder {
+	return &encoder{name: name, level: level, factory: factory}
+}
+
+// GzipEncoder returns the Encoder of "gzip" at the compress/gzip level
+func GzipEncoder(level int) Encoder {
+	return NewEncoder("gzip", level, func(w io.Writer, level int) (EncoderWriter, error) {
+		return gzip.NewWriterLevel(w, level)
+	})
+}
+
+// DeflateEncoder returns the Encoder of "deflate" at the compress/flate
+// level. The "deflate" content-coding is the zlib format of RFC 1950.
+func DeflateEncoder(level int) Encoder {
+	return NewEncoder("deflate", level, func(w io.Writer, level int) (EncoderWriter, error) {
+		return zlib.NewWriterLevel(w, level)
+	})
+}
+
+// encoderPool reuses the writers of an encoder across responses
+type encoderPool struct {
+	encoder Encoder
+	pool    sync.Pool
+}
+
+func newEncoderPool(e Encoder) *encoderPool {
+	// fail at setup rather than on the first request
+	ew, err := e.NewWriter(ioutil.Discard, e.Level())
+	if err != nil {
+		panic(fmt.Sprintf("middleware: invalid encoder %q: %v", e.Name(), err))
+	}
+
+	p := &encoderPool{encoder: e}
+	p.pool.Put(ew)
+	return p
+}
+
+func (p *encoderPool) get(w io.Writer) (EncoderWriter, error) {
+	if ew, ok := p.pool.Get().(EncoderWriter); ok {
+		ew.Reset(w)
+		return ew, nil
+	}
+	return p.encoder.NewWriter(w, p.encoder.Level())
+}
+
+func (p *encoderPool) put(ew EncoderWriter) {
+	ew.Reset(ioutil.Discard)
+	p.pool.Put(ew)
+}
+
+var defaultEncoders = []Encoder{
+	GzipEncoder(gzip.DefaultCompression),
+	DeflateEncoder(flate.DefaultCompression),
+}
@@ -0,0 +1,127 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const identityEncoding = "identity"
+
+// minQValue is the lowest non-zero weight expressible in a q-value
+const minQValue = 0.001
+
+type acceptedCoding struct {
+	coding string
+	q      float64
+}
+
+// parseAcceptEncoding parses the Accept-Encoding field values of RFC 9110
+// section 12.5.3. Codings are lowercased and malformed q-values are treated
+// as 0.
+func parseAcceptEncoding(values []string) []acceptedCoding {
+	var codings []acceptedCoding
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+
+			params := strings.Split(item, ";")
+			coding := strings.ToLower(strings.TrimSpace(params[0]))
+			if coding == "x-gzip" {
+				coding = "gzip"
+			}
+
+			q := 1.0
+			for _, param := range params[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(strings.TrimSpace(kv[0]), "q") {
+					continue
+				}
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
+				}
+				q = parsed
+			}
+
+			codings = append(codings, acceptedCoding{coding: coding, q: q})
+		}
+	}
+	return codings
+}
+
+// negotiateEncoding picks the coding of the response among supported, which
+// is in order of server preference. It returns "identity" if no compression
+// should be applied and false if no coding, including identity, is
+// acceptable.
+func negotiateEncoding(header http.Header, supported []string) (string, bool) {
+	values, present := header["Accept-Encoding"]
+	if !present {
+		// no preference from the client, stay on the safe side
+		return identityEncoding, true
+	}
+
+	codings := parseAcceptEncoding(values)
+
+	qOf := func(coding string) (float64, bool) {
+		for _, c := range codings {
+			if c.coding == coding {
+				return c.q, true
+			}
+		}
+		return 0, false
+	}
+
+	wildcard, hasWildcard := qOf("*")
+
+	best, bestQ := "", 0.0
+	for _, coding := range supported {
+		q, ok := qOf(coding)
+		if !ok && hasWildcard {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	identityQ, ok := qOf(identityEncoding)
+	if !ok {
+		// identity is always acceptable unless refused explicitly or by "*",
+		// but any coding the client lists is preferred over it
+		identityQ = minQValue
+		if hasWildcard {
+			identityQ = wildcard
+		}
+	}
+
+	if best != "" && bestQ >= identityQ {
+		return best, true
+	}
+	if identityQ > 0 {
+		return identityEncoding, true
+	}
+	return "", false
+}
+
+// addVary adds the field name to the Vary header unless already listed
+func addVary(header http.Header, name string) {
+	if headerContainsToken(header, "Vary", name) || headerContainsToken(header, "Vary", "*") {
+		return
+	}
+	header.Add("Vary", name)
+}
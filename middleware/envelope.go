@@ -0,0 +1,237 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// EncryptedContentType is the media type of enveloped payloads, with the
+// media type of the plaintext in the "type" parameter
+const EncryptedContentType = "application/x-zin-encrypted"
+
+// DefaultEnvelopeMaxSize is the limit of encrypted request bodies unless
+// configured
+const DefaultEnvelopeMaxSize = 1 << 20
+
+var ErrEnvelope = errors.New("middleware: malformed or forged envelope")
+
+// EnvelopeKeyFunc returns the AES key, of 16, 24 or 32 bytes, of the session
+// of r
+type EnvelopeKeyFunc func(r *http.Request) ([]byte, error)
+
+// EnvelopeConfig configures Envelope
+type EnvelopeConfig struct {
+	// Key returns the key of the session of the request
+	Key EnvelopeKeyFunc
+
+	// MaxSize limits the encrypted request body, DefaultEnvelopeMaxSize if
+	// not positive
+	MaxSize int64
+
+	// Optional lets plaintext requests through, answered in plaintext
+	Optional bool
+}
+
+// EnvelopeAdditionalData returns the data authenticated along a payload,
+// binding it to the request and direction so that payloads cannot be
+// replayed on other routes or as responses
+func EnvelopeAdditionalData(r *http.Request, response bool) []byte {
+	direction := "request"
+	if response {
+		direction = "response"
+	}
+	return []byte(direction + " " + r.Method + " " + r.URL.RequestURI())
+}
+
+// SealEnvelope encrypts plaintext by AES-GCM with a random nonce, returning
+// the nonce followed by the ciphertext. Random nonces are safe for about
+// 2^32 payloads per key, which per-session keys stay far below.
+func SealEnvelope(key, additionalData, plaintext []byte) ([]byte, error) {
+	aead, err := newEnvelopeAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// OpenEnvelope decrypts a payload of SealEnvelope
+func OpenEnvelope(key, additionalData, sealed []byte) ([]byte, error) {
+	aead, err := newEnvelopeAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrEnvelope
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrEnvelope
+	}
+	return plaintext, nil
+}
+
+func newEnvelopeAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// envelopeOf reports whether the body of r is encrypted, with the media type
+// of its plaintext, and whether r accepts encrypted responses
+func envelopeOf(r *http.Request) (encrypted bool, contentType string, accepted bool) {
+	if mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mediaType == EncryptedContentType {
+		return true, params["type"], true
+	}
+	for _, v := range r.Header["Accept"] {
+		for _, accept := range strings.Split(v, ",") {
+			if mediaType, _, err := mime.ParseMediaType(accept); err == nil && mediaType == EncryptedContentType {
+				return false, "", true
+			}
+		}
+	}
+	return false, "", false
+}
+
+func hasBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+// Envelope decrypts AES-GCM encrypted request bodies and encrypts response
+// bodies by the key of the session, see SealEnvelope. A request is enveloped
+// when its Content-Type or Accept is EncryptedContentType; the handler reads
+// the plaintext with the Content-Type of the "type" parameter, and the
+// response is encrypted with its Content-Type moved to that parameter.
+// Plaintext requests are replied with 415 unless config.Optional, where
+// plaintext bodies are passed as is and other responses in plaintext. Failed
+// key lookups with 401 and malformed or forged payloads with 400. Responses
+// without a body, to HEAD or of status 204 and 304, are left unsealed.
+//
+// Being innermost, Envelope composes with the other middlewares in the order
+//
+//	zin.NewGroup("/", Envelope(config), HMACVerifier(hmacConfig, entry), HMACSigner(hmacConfig), Compressor)
+//
+// so that request signatures are verified over the ciphertext before
+// decrypting and response signatures cover the ciphertext, while Compressor
+// leaves the incompressible EncryptedContentType untouched.
+func Envelope(config EnvelopeConfig) middleware {
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultEnvelopeMaxSize
+	}
+
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			encrypted, contentType, accepted := envelopeOf(r)
+			if !encrypted && hasBody(r) && !config.Optional {
+				http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+				return
+			}
+			if !accepted {
+				if !config.Optional {
+					http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+					return
+				}
+				h(w, r, p)
+				return
+			}
+
+			key, err := config.Key(r)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			r2 := r.Clone(r.Context())
+			if encrypted {
+				sealed, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, config.MaxSize))
+				r.Body.Close()
+				if err != nil {
+					http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+					return
+				}
+
+				plaintext, err := OpenEnvelope(key, EnvelopeAdditionalData(r, false), sealed)
+				if err != nil {
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+
+				r2.Body = ioutil.NopCloser(bytes.NewReader(plaintext))
+				r2.ContentLength = int64(len(plaintext))
+				r2.Header.Set("Content-Length", strconv.Itoa(len(plaintext)))
+				if contentType != "" {
+					r2.Header.Set("Content-Type", contentType)
+				} else {
+					r2.Header.Del("Content-Type")
+				}
+			}
+
+			bw := NewBufferWriter(w, 0)
+			defer bw.Close()
+			h(WrapWriter(w, bw), r2, p)
+			if bw.Hijacked() {
+				return
+			}
+			if status := bw.Status(); r.Method == http.MethodHead || status == http.StatusNoContent || status == http.StatusNotModified {
+				bw.Commit()
+				return
+			}
+
+			body, err := bw.Bytes()
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			header := bw.Header()
+			if plainType := header.Get("Content-Type"); plainType != "" {
+				header.Set("Content-Type", mime.FormatMediaType(EncryptedContentType, map[string]string{"type": plainType}))
+			} else if len(body) > 0 {
+				header.Set("Content-Type", mime.FormatMediaType(EncryptedContentType, map[string]string{"type": http.DetectContentType(body)}))
+			} else {
+				header.Set("Content-Type", EncryptedContentType)
+			}
+
+			sealed, err := SealEnvelope(key, EnvelopeAdditionalData(r, true), body)
+			if err == nil {
+				err = bw.ResetBody()
+			}
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			header.Del("Content-Length")
+			bw.Write(sealed)
+			bw.Commit()
+		}
+	}
+}
@@ -0,0 +1,166 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	. "github.com/rayark/zin/v2/middleware"
+)
+
+var envelopeKey = []byte("0123456789abcdef0123456789abcdef")
+
+func envelopeTestKey(r *http.Request) ([]byte, error) {
+	if r.Header.Get("Session") != "s1" {
+		return nil, errors.New("unknown session")
+	}
+	return envelopeKey, nil
+}
+
+func middlewareEnvelopeTest(t *testing.T, config EnvelopeConfig, req *http.Request) *httptest.ResponseRecorder {
+	router := httprouter.New()
+	grp := zin.NewGroup("/", Envelope(config), HMACSHA1Signer(hmacHeaderKey, "", []byte(secretString)), Compressor)
+	grp.R(router.GET, "/empty", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	grp.R(router.HEAD, "/echo", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+	})
+	grp.R(router.POST, "/echo", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(strings.Repeat(`{"type":"`+r.Header.Get("Content-Type")+`","body":`+string(body)+`}`, 100)))
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func newEnvelopeRequest(t *testing.T, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/echo?x=1", nil)
+	sealed, err := SealEnvelope(envelopeKey, EnvelopeAdditionalData(req, false), []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(sealed))
+	req.ContentLength = int64(len(sealed))
+	req.Header.Set("Content-Type", EncryptedContentType+`; type="application/json"`)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Session", "s1")
+	return req
+}
+
+func TestEnvelope(t *testing.T) {
+	config := EnvelopeConfig{Key: envelopeTestKey}
+
+	req := newEnvelopeRequest(t, `{"a":1}`)
+	rec := middlewareEnvelopeTest(t, config, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", rec.Code)
+	}
+
+	if ct := rec.HeaderMap.Get("Content-Type"); ct != EncryptedContentType+`; type="application/json"` {
+		t.Errorf("expected encrypted content type got '%s'", ct)
+	}
+	if ce := rec.HeaderMap.Get("Content-Encoding"); ce != "" {
+		t.Errorf("expected ciphertext left uncompressed got '%s'", ce)
+	}
+	if sig := rec.HeaderMap.Get(hmacHeaderKey); sig != generateSignature(rec.Body.Bytes(), []byte(secretString)) {
+		t.Errorf("expected signature over the ciphertext got '%s'", sig)
+	}
+
+	plaintext, err := OpenEnvelope(envelopeKey, EnvelopeAdditionalData(req, true), rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := strings.Repeat(`{"type":"application/json","body":{"a":1}}`, 100); string(plaintext) != expected {
+		t.Errorf("unexpected plaintext '%s'", plaintext)
+	}
+
+	// a response is not accepted as a request
+	if _, err := OpenEnvelope(envelopeKey, EnvelopeAdditionalData(req, false), rec.Body.Bytes()); err == nil {
+		t.Error("expected response payload rejected as request")
+	}
+}
+
+func TestEnvelopeNoBody(t *testing.T) {
+	config := EnvelopeConfig{Key: envelopeTestKey}
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/empty", nil),
+		httptest.NewRequest("HEAD", "/echo", nil),
+	} {
+		req.Header.Set("Accept", EncryptedContentType)
+		req.Header.Set("Session", "s1")
+
+		rec := middlewareEnvelopeTest(t, config, req)
+		if rec.Body.Len() != 0 || rec.HeaderMap.Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s: expected response left unsealed got %d '%s' %q", req.Method, req.URL, rec.Code, rec.HeaderMap.Get("Content-Type"), rec.Body.Bytes())
+		}
+	}
+}
+
+func TestEnvelopeMaxSize(t *testing.T) {
+	req := newEnvelopeRequest(t, `{"a":"`+strings.Repeat("a", 100)+`"}`)
+	if rec := middlewareEnvelopeTest(t, EnvelopeConfig{Key: envelopeTestKey, MaxSize: 64}, req); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 past MaxSize got %d", rec.Code)
+	}
+}
+
+func TestEnvelopeRejects(t *testing.T) {
+	config := EnvelopeConfig{Key: envelopeTestKey, MaxSize: 1 << 20}
+
+	plain := httptest.NewRequest("POST", "/echo", strings.NewReader(`{"a":1}`))
+	plain.Header.Set("Content-Type", "application/json")
+	if rec := middlewareEnvelopeTest(t, config, plain); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 for plaintext got %d", rec.Code)
+	}
+
+	unknown := newEnvelopeRequest(t, `{"a":1}`)
+	unknown.Header.Set("Session", "s2")
+	if rec := middlewareEnvelopeTest(t, config, unknown); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for unknown session got %d", rec.Code)
+	}
+
+	tampered := newEnvelopeRequest(t, `{"a":1}`)
+	sealed, _ := ioutil.ReadAll(tampered.Body)
+	sealed[len(sealed)-1] ^= 1
+	tampered.Body = ioutil.NopCloser(bytes.NewReader(sealed))
+	if rec := middlewareEnvelopeTest(t, config, tampered); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for tampered payload got %d", rec.Code)
+	}
+
+	moved := newEnvelopeRequest(t, `{"a":1}`)
+	moved.URL.RawQuery = "x=2"
+	moved.RequestURI = "/echo?x=2"
+	if rec := middlewareEnvelopeTest(t, config, moved); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for payload of another request got %d", rec.Code)
+	}
+
+	config.Optional = true
+	plain = httptest.NewRequest("POST", "/echo", strings.NewReader(`{"a":1}`))
+	plain.Header.Set("Content-Type", "application/json")
+	rec := middlewareEnvelopeTest(t, config, plain)
+	if rec.Code != http.StatusOK || rec.HeaderMap.Get("Content-Type") != "application/json" {
+		t.Errorf("expected plaintext passed when optional got %d '%s'", rec.Code, rec.HeaderMap.Get("Content-Type"))
+	}
+}
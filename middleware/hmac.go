@@ -12,40 +12,548 @@
 package middleware
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// HMACAlgorithm is the hash of hmac signatures, Name is used as the prefix
+// of FormatPrefixed signatures
+type HMACAlgorithm struct {
+	Name string
+	New  func() hash.Hash
+}
+
+var (
+	// HMACSHA1 is kept for legacy clients only
+	HMACSHA1   = HMACAlgorithm{Name: "sha1", New: sha1.New}
+	HMACSHA256 = HMACAlgorithm{Name: "sha256", New: sha256.New}
+	HMACSHA512 = HMACAlgorithm{Name: "sha512", New: sha512.New}
+)
+
+type SignatureEncoding int
+
+const (
+	EncodingHex SignatureEncoding = iota
+	EncodingBase64
+	EncodingBase64URL
+)
+
+func (e SignatureEncoding) encode(b []byte) string {
+	switch e {
+	case EncodingBase64:
+		return base64.StdEncoding.EncodeToString(b)
+	case EncodingBase64URL:
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (e SignatureEncoding) decode(s string) ([]byte, error) {
+	switch e {
+	case EncodingBase64:
+		return base64.StdEncoding.DecodeString(s)
+	case EncodingBase64URL:
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	}
+	return hex.DecodeString(s)
+}
+
+type SignatureFormat int
+
+const (
+	// FormatPrefixed writes "<algorithm>=<signature>", e.g. "sha1=..."
+	FormatPrefixed SignatureFormat = iota
+	// FormatPlain writes the encoded signature alone
+	FormatPlain
+)
+
+// HMACConfig describes how signatures are computed and carried, so that
+// signers and verifiers built from the same config agree
+type HMACConfig struct {
+	Algorithm HMACAlgorithm
+	Encoding  SignatureEncoding
+	Format    SignatureFormat
+
+	// Header carries the signature
+	Header string
+
+	// NonceHeader, if not empty, carries a hex nonce. The secret is extended
+	// with the nonce to derive the key of each request, and the nonce is
+	// covered by request signatures.
+	NonceHeader string
+
+	// TimestampHeader, if not empty, enables replay protection of requests.
+	// It carries the signing time in unix seconds, covered by the signature
+	// and rejected when off by more than MaxClockSkew. Nonces are then
+	// required and tracked in NonceStore until they would be rejected by the
+	// timestamp anyway.
+	TimestampHeader string
+	MaxClockSkew    time.Duration
+	NonceStore      NonceStore
+
+	// SignedHeaders are the request headers covered by request signatures
+	SignedHeaders []string
+
+	// Secret is the shared secret, unless Keyring is set
+	Secret []byte
+
+	// Keyring, if set, replaces Secret. The key ID is carried in KeyIDHeader:
+	// requests must name a key not retired, and responses are signed with
+	// the key of the request if still accepted, else with the current key.
+	// Requests without KeyIDHeader, e.g. of builds predating rotation, use
+	// the key of LegacyKeyID, and are rejected if it is empty.
+	Keyring     *Keyring
+	KeyIDHeader string
+	LegacyKeyID string
+
+	// Trailer makes signers stream the response and send the signature as
+	// an HTTP trailer declared in the Trailer header, instead of buffering
+	// the whole body to send it as a header. Clients must read trailers.
+	Trailer bool
+
+	// MemoryLimit is the size of buffered responses kept in memory before
+	// spilling to a temp file, DefaultBufferMemoryLimit if zero
+	MemoryLimit int64
+
+	// MaxBodySize limits the request bodies read by HMACVerifier before the
+	// signature is checked, DefaultMaxBodySize if not positive
+	MaxBodySize int64
+}
+
+// DefaultMaxBodySize is the limit of request bodies read by verifiers unless
+// configured
+const DefaultMaxBodySize = 1 << 20
+
+// HMACSHA1Config returns the config of HMACSHA1Signer, hex encoded with the
+// "sha1=" prefix
+func HMACSHA1Config(hmacHeaderKey, nounceHeaderKey string, secret []byte) HMACConfig {
+	return HMACConfig{
+		Algorithm:   HMACSHA1,
+		Encoding:    EncodingHex,
+		Format:      FormatPrefixed,
+		Header:      hmacHeaderKey,
+		NonceHeader: nounceHeaderKey,
+		Secret:      secret,
+	}
+}
+
+// keyID returns the ID of the key r is signed with
+func (c *HMACConfig) keyID(r *http.Request) string {
+	if id := r.Header.Get(c.KeyIDHeader); id != "" {
+		return id
+	}
+	return c.LegacyKeyID
+}
+
+// signingKey returns the ID and the secret signing the response of r, the
+// ID is empty without keyring
+func (c *HMACConfig) signingKey(r *http.Request) (string, []byte) {
+	if c.Keyring == nil {
+		return "", c.Secret
+	}
+	if key, ok := c.Keyring.Lookup(c.keyID(r)); ok {
+		return key.ID, key.Secret
+	}
+	key := c.Keyring.Current()
+	return key.ID, key.Secret
+}
+
+// verifyingKey returns the secret verifying the signature of r
+func (c *HMACConfig) verifyingKey(r *http.Request) ([]byte, bool) {
+	if c.Keyring == nil {
+		return c.Secret, true
+	}
+	key, ok := c.Keyring.Lookup(c.keyID(r))
+	return key.Secret, ok
+}
+
+// key derives the key of the request from secret, the secret itself is never
+// written since it is shared by concurrent requests
+func (c *HMACConfig) key(r *http.Request, secret []byte) []byte {
+	if c.NonceHeader == "" {
+		return secret
+	}
+
+	nounce, err := hex.DecodeString(r.Header.Get(c.NonceHeader))
+	if err != nil || len(nounce) == 0 {
+		return secret
+	}
+
+	key := make([]byte, 0, len(secret)+len(nounce))
+	key = append(key, secret...)
+	return append(key, nounce...)
+}
+
+// signedHeaders returns the request headers covered by the signature,
+// including the timestamp and the nonce
+func (c *HMACConfig) signedHeaders() []string {
+	headers := c.SignedHeaders
+	for _, name := range []string{c.TimestampHeader, c.NonceHeader} {
+		if name != "" && !containsHeader(headers, name) {
+			headers = append(headers[:len(headers):len(headers)], name)
+		}
+	}
+	return headers
+}
+
+func containsHeader(headers []string, name string) bool {
+	for _, h := range headers {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+const defaultMaxClockSkew = 5 * time.Minute
+
+func (c *HMACConfig) maxClockSkew() time.Duration {
+	if c.MaxClockSkew > 0 {
+		return c.MaxClockSkew
+	}
+	return defaultMaxClockSkew
+}
+
+// checkTimestamp returns the reason to reject the timestamp of r, and the
+// time until which its nonce must be remembered
+func (c *HMACConfig) checkTimestamp(r *http.Request) (string, time.Time) {
+	value := r.Header.Get(c.TimestampHeader)
+	if value == "" {
+		return "missing timestamp", time.Time{}
+	}
+
+	sec, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "malformed timestamp", time.Time{}
+	}
+
+	skew := c.maxClockSkew()
+	ts := time.Unix(sec, 0)
+	if d := time.Since(ts); d > skew || d < -skew {
+		return "timestamp out of allowed clock skew", time.Time{}
+	}
+
+	if c.NonceHeader != "" && r.Header.Get(c.NonceHeader) == "" {
+		return "missing nonce", time.Time{}
+	}
+	return "", ts.Add(skew)
+}
+
+func (c *HMACConfig) mac(msg, key []byte) []byte {
+	h := hmac.New(c.Algorithm.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// format returns the header value carrying the signature
+func (c *HMACConfig) format(sum []byte) string {
+	signature := c.Encoding.encode(sum)
+	if c.Format == FormatPrefixed {
+		return c.Algorithm.Name + "=" + signature
+	}
+	return signature
+}
+
+// parse returns the signature carried by the header value
+func (c *HMACConfig) parse(value string) ([]byte, bool) {
+	if c.Format == FormatPrefixed {
+		prefix := c.Algorithm.Name + "="
+		if len(value) < len(prefix) || !strings.EqualFold(value[:len(prefix)], prefix) {
+			return nil, false
+		}
+		value = value[len(prefix):]
+	}
+
+	sum, err := c.Encoding.decode(value)
+	if err != nil {
+		return nil, false
+	}
+	return sum, true
+}
+
 // HMACSHA1Signer returns a middleware wrapper to add hmac signing string in
 // response header
 func HMACSHA1Signer(hmacHeaderKey, nounceHeaderKey string, secret []byte) middleware {
+	return HMACSigner(HMACSHA1Config(hmacHeaderKey, nounceHeaderKey, secret))
+}
+
+// HMACSigner returns a middleware wrapper to add the hmac signature of the
+// response body in the config header, or in the trailer of the streamed
+// response if config.Trailer is set
+func HMACSigner(config HMACConfig) middleware {
+	if config.Trailer {
+		return hmacTrailerSigner(config)
+	}
+
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			bw := NewBufferWriter(w, config.MemoryLimit)
+			defer bw.Close()
+
+			keyID, secret := config.signingKey(r)
+			mac := hmac.New(config.Algorithm.New, config.key(r, secret))
+
+			h(WrapWriter(w, bw), r, p)
+			if bw.Hijacked() {
+				return
+			}
+
+			body, err := bw.Body()
+			if err == nil {
+				_, err = io.Copy(mac, body)
+			}
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			if keyID != "" {
+				bw.Header().Set(config.KeyIDHeader, keyID)
+			}
+			bw.Header().Set(config.Header, config.format(mac.Sum(nil)))
+			bw.Commit()
+		}
+	}
+}
+
+func hmacTrailerSigner(config HMACConfig) middleware {
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			keyID, secret := config.signingKey(r)
+			if keyID != "" {
+				w.Header().Set(config.KeyIDHeader, keyID)
+			}
+			w.Header().Add("Trailer", config.Header)
+
+			sw := &hmacStreamWriter{
+				ResponseWriter: w,
+				mac:            hmac.New(config.Algorithm.New, config.key(r, secret)),
+			}
+			h(WrapWriter(w, sw), r, p)
+			if sw.hijacked {
+				return
+			}
+
+			// send the signature as a trailer even of empty bodies
+			if !sw.wroteHeader {
+				sw.WriteHeader(http.StatusOK)
+			}
+			w.Header().Set(config.Header, config.format(sw.mac.Sum(nil)))
+		}
+	}
+}
+
+// hmacStreamWriter writes the response through while computing its hmac
+type hmacStreamWriter struct {
+	http.ResponseWriter
+	mac         hash.Hash
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (w *hmacStreamWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status >= 200 {
+		w.wroteHeader = true
+		// trailers are carried by the chunked encoding only
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *hmacStreamWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.mac.Write(b[:n])
+	return n, err
+}
+
+func (w *hmacStreamWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *hmacStreamWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(w.ResponseWriter)
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (w *hmacStreamWriter) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(writerOnly{w}, src)
+}
+
+func (w *hmacStreamWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+func (w *hmacStreamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// HMACSHA1Verifier returns a middleware wrapper rejecting requests with 401
+// unless hmacHeaderKey carries the hmac signature of the request, computed
+// over the method, the request URI, signedHeaders and the body. The body is
+// restored for the handler.
+func HMACSHA1Verifier(hmacHeaderKey string, signedHeaders []string, secret []byte, entry LogEntry) middleware {
+	config := HMACSHA1Config(hmacHeaderKey, "", secret)
+	config.SignedHeaders = signedHeaders
+	return HMACVerifier(config, entry)
+}
+
+// HMACVerifier returns a middleware wrapper rejecting requests with 401
+// unless the config header carries the hmac signature of the request, or
+// with 413 when the body exceeds config.MaxBodySize. The reason of
+// rejections is logged to entry.
+func HMACVerifier(config HMACConfig, entry LogEntry) middleware {
+	if config.TimestampHeader != "" && config.NonceHeader != "" && config.NonceStore == nil {
+		config.NonceStore = NewMemoryNonceStore()
+	}
+
 	return func(h httprouter.Handle) httprouter.Handle {
 		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-			deferWriter := NewDeferWriter(w)
-			defer deferWriter.WriteAll()
-
-			key := secret
-			if nounceHeaderKey != "" {
-				nounceInHex := r.Header.Get(nounceHeaderKey)
-				nounce, err := hex.DecodeString(nounceInHex)
-				if err == nil {
-					key = append(key, nounce...)
+			fail := func(status int, reason string) {
+				entry.
+					WithField("reason", reason).
+					Warningf("hmac verification failed: %s %s", r.Method, r.URL.String())
+				http.Error(w, http.StatusText(status), status)
+			}
+			reject := func(reason string) {
+				fail(http.StatusUnauthorized, reason)
+			}
+
+			value := r.Header.Get(config.Header)
+			if value == "" {
+				reject("missing signature")
+				return
+			}
+
+			signature, ok := config.parse(value)
+			if !ok {
+				reject("malformed signature")
+				return
+			}
+
+			secret, ok := config.verifyingKey(r)
+			if !ok {
+				reject("unknown or retired key")
+				return
+			}
+
+			var nonceExpiry time.Time
+			if config.TimestampHeader != "" {
+				var reason string
+				if reason, nonceExpiry = config.checkTimestamp(r); reason != "" {
+					reject(reason)
+					return
 				}
 			}
 
-			h(deferWriter, r, p)
-			hmacSignature := generateSignature(deferWriter.Bytes(), key)
-			deferWriter.Header().Set(hmacHeaderKey, hmacSignature)
+			body, err := readLimitedBody(w, r, config.MaxBodySize)
+			if err == errBodyTooLarge {
+				fail(http.StatusRequestEntityTooLarge, "body too large")
+				return
+			} else if err != nil {
+				reject("unreadable body")
+				return
+			}
+
+			msg := requestSigningMessage(r, config.signedHeaders(), body)
+			if !hmac.Equal(signature, config.mac(msg, config.key(r, secret))) {
+				reject("signature mismatch")
+				return
+			}
+
+			// record nonces of authentic requests only, forged ones must not
+			// burn the nonces of legitimate clients
+			if config.NonceStore != nil && config.TimestampHeader != "" && config.NonceHeader != "" {
+				if !config.NonceStore.Use(r.Header.Get(config.NonceHeader), nonceExpiry) {
+					reject("replayed nonce")
+					return
+				}
+			}
+
+			h(w, r, p)
 		}
 	}
 }
 
-func generateSignature(msg, key []byte) string {
-	h := hmac.New(sha1.New, key)
-	h.Write(msg)
-	return "sha1=" + hex.EncodeToString(h.Sum(nil))
+// readBody reads the whole request body and restores it for the handler
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+var errBodyTooLarge = errors.New("middleware: request body too large")
+
+// readLimitedBody is readBody failing past limit, DefaultMaxBodySize if not
+// positive, so that unauthenticated clients cannot make the server buffer
+// unlimited bodies
+func readLimitedBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	r.Body.Close()
+	if err != nil && int64(len(body)) == limit {
+		// MaxBytesReader fails once past the limit
+		return nil, errBodyTooLarge
+	} else if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// requestSigningMessage returns the message signed for a request: the
+// method, the request URI and each of headers as "name:value" on their own
+// line, followed by an empty line and the body
+func requestSigningMessage(r *http.Request, headers []string, body []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(r.Method + "\n")
+	buf.WriteString(r.URL.RequestURI() + "\n")
+	for _, name := range headers {
+		value := strings.Join(r.Header[http.CanonicalHeaderKey(name)], ",")
+		buf.WriteString(strings.ToLower(name) + ":" + strings.TrimSpace(value) + "\n")
+	}
+	buf.WriteString("\n")
+	buf.Write(body)
+	return buf.Bytes()
 }
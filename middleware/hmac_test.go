@@ -14,10 +14,19 @@ package middleware_test
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/hex"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/rayark/zin/v2"
@@ -115,3 +124,307 @@ func generateSignature(msg, key []byte) string {
 	h.Write(msg)
 	return "sha1=" + hex.EncodeToString(h.Sum(nil))
 }
+
+type recordEntry struct {
+	fields map[string]interface{}
+	logs   *[]string
+}
+
+func newRecordEntry() recordEntry {
+	return recordEntry{fields: map[string]interface{}{}, logs: new([]string)}
+}
+
+func (e recordEntry) WithField(k string, v interface{}) LogEntry {
+	e.fields[k] = v
+	return e
+}
+func (e recordEntry) Infof(f string, args ...interface{}) {
+	*e.logs = append(*e.logs, fmt.Sprintf(f, args...))
+}
+func (e recordEntry) Warningf(f string, args ...interface{}) {
+	*e.logs = append(*e.logs, fmt.Sprintf(f, args...))
+}
+func (e recordEntry) Errorf(f string, args ...interface{}) {
+	*e.logs = append(*e.logs, fmt.Sprintf(f, args...))
+}
+
+func middlewareHMACVerifierTest(t *testing.T, signature string, body string, entry LogEntry) *httptest.ResponseRecorder {
+	path := "/hmac?player=1"
+
+	req, err := http.NewRequest("POST", path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Client-Version", "1.2.3")
+	if signature != "" {
+		req.Header.Set(hmacHeaderKey, signature)
+	}
+
+	router := httprouter.New()
+	grp := zin.NewGroup("/", HMACSHA1Verifier(hmacHeaderKey, []string{"X-Client-Version"}, []byte(secretString), entry))
+	grp.R(router.POST, "/hmac", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write(b)
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestHMACVerifier(t *testing.T) {
+	msg := "POST\n/hmac?player=1\nx-client-version:1.2.3\n\n" + bodyContent
+	signature := generateSignature([]byte(msg), []byte(secretString))
+
+	rec := middlewareHMACVerifierTest(t, signature, bodyContent, newRecordEntry())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got '%d'", rec.Code)
+	}
+	if rec.Body.String() != bodyContent {
+		t.Fatalf("body not restored for handler")
+	}
+
+	entry := newRecordEntry()
+	rec = middlewareHMACVerifierTest(t, signature, bodyContent+"tampered", entry)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 got '%d'", rec.Code)
+	}
+	if entry.fields["reason"] != "signature mismatch" || len(*entry.logs) != 1 {
+		t.Fatalf("expected reason logged got %v", entry.fields)
+	}
+
+	entry = newRecordEntry()
+	rec = middlewareHMACVerifierTest(t, "", bodyContent, entry)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 got '%d'", rec.Code)
+	}
+	if entry.fields["reason"] != "missing signature" {
+		t.Fatalf("expected reason logged got %v", entry.fields)
+	}
+}
+
+func TestHMACVerifierMaxBodySize(t *testing.T) {
+	config := HMACSHA1Config(hmacHeaderKey, "", []byte(secretString))
+	config.MaxBodySize = 16
+
+	entry := newRecordEntry()
+	called := false
+	router := httprouter.New()
+	grp := zin.NewGroup("/", HMACVerifier(config, entry))
+	grp.R(router.POST, "/hmac", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		called = true
+	})
+
+	// the body is limited before its signature can be checked
+	req := httptest.NewRequest("POST", "/hmac", strings.NewReader(strings.Repeat("a", 17)))
+	req.Header.Set(hmacHeaderKey, "sha1=00")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge || called {
+		t.Fatalf("expected 413 got %d", rec.Code)
+	}
+	if entry.fields["reason"] != "body too large" {
+		t.Fatalf("expected reason logged got %v", entry.fields)
+	}
+}
+
+func TestHMACSignerAlgorithms(t *testing.T) {
+	cases := []struct {
+		config   HMACConfig
+		expected func([]byte) string
+	}{
+		{
+			HMACConfig{Algorithm: HMACSHA256, Encoding: EncodingBase64, Format: FormatPlain},
+			func(msg []byte) string {
+				h := hmac.New(sha256.New, []byte(secretString))
+				h.Write(msg)
+				return base64.StdEncoding.EncodeToString(h.Sum(nil))
+			},
+		},
+		{
+			HMACConfig{Algorithm: HMACSHA512, Encoding: EncodingBase64URL, Format: FormatPrefixed},
+			func(msg []byte) string {
+				h := hmac.New(sha512.New, []byte(secretString))
+				h.Write(msg)
+				return "sha512=" + base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+			},
+		},
+	}
+
+	for _, c := range cases {
+		config := c.config
+		config.Header = hmacHeaderKey
+		config.Secret = []byte(secretString)
+
+		req, err := http.NewRequest("GET", "/hmac", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		router := httprouter.New()
+		grp := zin.NewGroup("/", HMACSigner(config))
+		grp.R(router.GET, "/hmac", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.Write([]byte(bodyContent))
+		})
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.HeaderMap.Get(hmacHeaderKey) != c.expected([]byte(bodyContent)) {
+			t.Fatalf("%s: unexpected signature '%s'", config.Algorithm.Name, rec.HeaderMap.Get(hmacHeaderKey))
+		}
+	}
+}
+
+func TestHMACSignerSecretNotMutated(t *testing.T) {
+	// spare capacity would be written by appending the nonce in place
+	secret := make([]byte, len(secretString), 64)
+	copy(secret, secretString)
+
+	router := httprouter.New()
+	grp := zin.NewGroup("/", HMACSHA1Signer(hmacHeaderKey, nounceHeaderKey, secret))
+	grp.R(router.GET, "/hmac", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		time.Sleep(time.Millisecond)
+		w.Write([]byte(bodyContent))
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(nounce string) {
+			defer wg.Done()
+
+			req, _ := http.NewRequest("GET", "/hmac", nil)
+			req.Header.Set(nounceHeaderKey, hex.EncodeToString([]byte(nounce)))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			key := append([]byte(secretString), []byte(nounce)...)
+			if rec.HeaderMap.Get(hmacHeaderKey) != generateSignature([]byte(bodyContent), key) {
+				t.Errorf("signature of nounce %s corrupted", nounce)
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+}
+
+func TestHMACVerifierReplay(t *testing.T) {
+	config := HMACConfig{
+		Algorithm:       HMACSHA256,
+		Header:          hmacHeaderKey,
+		NonceHeader:     nounceHeaderKey,
+		TimestampHeader: "HMAC-Timestamp",
+		MaxClockSkew:    time.Minute,
+		Secret:          []byte(secretString),
+	}
+
+	router := httprouter.New()
+	grp := zin.NewGroup("/", HMACVerifier(config, newRecordEntry()))
+	grp.R(router.GET, "/hmac", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Write([]byte(bodyContent))
+	})
+
+	serve := func(ts time.Time, nounce string) int {
+		timestamp := strconv.FormatInt(ts.Unix(), 10)
+		nounceInHex := hex.EncodeToString([]byte(nounce))
+
+		req, _ := http.NewRequest("GET", "/hmac", nil)
+		req.Header.Set("HMAC-Timestamp", timestamp)
+		req.Header.Set(nounceHeaderKey, nounceInHex)
+
+		msg := "GET\n/hmac\nhmac-timestamp:" + timestamp + "\nnounce-for-hmac:" + nounceInHex + "\n\n"
+		h := hmac.New(sha256.New, append([]byte(secretString), []byte(nounce)...))
+		h.Write([]byte(msg))
+		req.Header.Set(hmacHeaderKey, "sha256="+hex.EncodeToString(h.Sum(nil)))
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := serve(time.Now(), "first"); code != http.StatusOK {
+		t.Fatalf("expected 200 got '%d'", code)
+	}
+	if code := serve(time.Now(), "first"); code != http.StatusUnauthorized {
+		t.Fatalf("expected replay rejected with 401 got '%d'", code)
+	}
+	if code := serve(time.Now().Add(-time.Hour), "second"); code != http.StatusUnauthorized {
+		t.Fatalf("expected stale timestamp rejected with 401 got '%d'", code)
+	}
+	if code := serve(time.Now(), "second"); code != http.StatusOK {
+		t.Fatalf("expected 200 got '%d'", code)
+	}
+}
+
+func TestHMACSignerTrailer(t *testing.T) {
+	config := HMACSHA1Config(hmacHeaderKey, "", []byte(secretString))
+	config.Trailer = true
+
+	router := httprouter.New()
+	grp := zin.NewGroup("/", HMACSigner(config))
+	grp.R(router.GET, "/hmac", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Header().Set("Content-Length", strconv.Itoa(2*len(bodyContent)))
+		w.Write([]byte(bodyContent))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(bodyContent))
+	})
+	grp.R(router.GET, "/empty", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {})
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	for path, body := range map[string]string{"/hmac": bodyContent + bodyContent, "/empty": ""} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Header.Get(hmacHeaderKey) != "" {
+			t.Errorf("%s: expected no signature header got '%s'", path, resp.Header.Get(hmacHeaderKey))
+		}
+		// the client moves declared trailers from the header to resp.Trailer
+		if _, ok := resp.Trailer[http.CanonicalHeaderKey(hmacHeaderKey)]; !ok {
+			t.Errorf("%s: expected trailer declared got %v", path, resp.Trailer)
+		}
+
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != body {
+			t.Errorf("%s: expected body '%s' got '%s'", path, body, b)
+		}
+
+		expected := generateSignature([]byte(body), []byte(secretString))
+		if resp.Trailer.Get(hmacHeaderKey) != expected {
+			t.Errorf("%s: expected trailer %s got '%s'", path, expected, resp.Trailer.Get(hmacHeaderKey))
+		}
+	}
+}
+
+func TestHMACSignerAfterWriteHeader(t *testing.T) {
+	config := HMACSHA1Config(hmacHeaderKey, "", []byte(secretString))
+	config.MemoryLimit = 8
+
+	router := httprouter.New()
+	grp := zin.NewGroup("/", HMACSigner(config))
+	grp.R(router.GET, "/hmac", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(bodyContent))
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/hmac", nil))
+
+	if rec.Code != http.StatusCreated {
+		t.Errorf("expected 201 got %d", rec.Code)
+	}
+	if rec.Body.String() != bodyContent {
+		t.Errorf("expected body '%s' got '%s'", bodyContent, rec.Body.String())
+	}
+	if sig := rec.HeaderMap.Get(hmacHeaderKey); sig != generateSignature([]byte(bodyContent), []byte(secretString)) {
+		t.Errorf("expected signature of explicit status got '%s'", sig)
+	}
+}
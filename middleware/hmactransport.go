@@ -0,0 +1,152 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrResponseSignature is returned by HMACTransport for responses without a
+// valid signature
+var ErrResponseSignature = errors.New("middleware: invalid response signature")
+
+// HMACTransport is an http.RoundTripper signing requests as HMACVerifier
+// expects and verifying the signatures of HMACSigner, built from the same
+// config as the server. Unsigned responses are errors, including rejections
+// of middlewares outside HMACSigner.
+type HMACTransport struct {
+	Config HMACConfig
+
+	// Base sends the requests, http.DefaultTransport if nil
+	Base http.RoundTripper
+}
+
+func (t *HMACTransport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *HMACTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	config := &t.Config
+
+	// a RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+
+	secret := config.Secret
+	if config.Keyring != nil {
+		key := config.Keyring.Current()
+		req.Header.Set(config.KeyIDHeader, key.ID)
+		secret = key.Secret
+	}
+	if config.TimestampHeader != "" {
+		req.Header.Set(config.TimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	}
+	if config.NonceHeader != "" && req.Header.Get(config.NonceHeader) == "" {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		req.Header.Set(config.NonceHeader, hex.EncodeToString(nonce))
+	}
+
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	msg := requestSigningMessage(req, config.signedHeaders(), body)
+	req.Header.Set(config.Header, config.format(config.mac(msg, config.key(req, secret))))
+
+	resp, err := t.base().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.verifyResponse(req, resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// verifyResponse checks the signature header of resp, or wraps the body to
+// check the signature trailer once read
+func (t *HMACTransport) verifyResponse(req *http.Request, resp *http.Response) error {
+	config := &t.Config
+
+	secret := config.Secret
+	if config.Keyring != nil {
+		key, ok := config.Keyring.Lookup(resp.Header.Get(config.KeyIDHeader))
+		if !ok {
+			return ErrResponseSignature
+		}
+		secret = key.Secret
+	}
+	mac := hmac.New(config.Algorithm.New, config.key(req, secret))
+
+	if config.Trailer {
+		resp.Body = &hmacTrailerReader{ReadCloser: resp.Body, resp: resp, config: config, mac: mac}
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	mac.Write(body)
+	signature, ok := config.parse(resp.Header.Get(config.Header))
+	if !ok || !hmac.Equal(signature, mac.Sum(nil)) {
+		return ErrResponseSignature
+	}
+	return nil
+}
+
+// hmacTrailerReader computes the hmac of the body and fails the read at EOF
+// unless the trailer carries it
+type hmacTrailerReader struct {
+	io.ReadCloser
+	resp   *http.Response
+	config *HMACConfig
+	mac    hash.Hash
+}
+
+func (r *hmacTrailerReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.mac.Write(b[:n])
+	if err == io.EOF {
+		signature, ok := r.config.parse(r.resp.Trailer.Get(r.config.Header))
+		if !ok || !hmac.Equal(signature, r.mac.Sum(nil)) {
+			return n, ErrResponseSignature
+		}
+	}
+	return n, err
+}
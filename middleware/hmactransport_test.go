@@ -0,0 +1,112 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	. "github.com/rayark/zin/v2/middleware"
+)
+
+func hmacTransportTestConfig(t *testing.T, trailer bool) HMACConfig {
+	keyring, err := NewKeyring("k2",
+		HMACKey{ID: "k1", Secret: []byte("old secret")},
+		HMACKey{ID: "k2", Secret: []byte(secretString)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return HMACConfig{
+		Algorithm:       HMACSHA256,
+		Encoding:        EncodingBase64,
+		Format:          FormatPrefixed,
+		Header:          hmacHeaderKey,
+		NonceHeader:     nounceHeaderKey,
+		TimestampHeader: "HMAC-Timestamp",
+		SignedHeaders:   []string{"Content-Type"},
+		Keyring:         keyring,
+		KeyIDHeader:     "HMAC-Key-ID",
+		Trailer:         trailer,
+	}
+}
+
+func hmacTransportTestServer(config HMACConfig, handle httprouter.Handle) *httptest.Server {
+	router := httprouter.New()
+	grp := zin.NewGroup("/", HMACSigner(config), HMACVerifier(config, newRecordEntry()))
+	grp.R(router.POST, "/hmac", handle)
+	return httptest.NewServer(router)
+}
+
+func TestHMACTransport(t *testing.T) {
+	for _, trailer := range []bool{false, true} {
+		config := hmacTransportTestConfig(t, trailer)
+		server := hmacTransportTestServer(config, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Write(append([]byte("echo: "), body...))
+		})
+
+		client := &http.Client{Transport: &HMACTransport{Config: config}}
+		for i := 0; i < 2; i++ {
+			resp, err := client.Post(server.URL+"/hmac", "text/plain", strings.NewReader(bodyContent))
+			if err != nil {
+				t.Fatalf("trailer %v: %v", trailer, err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("trailer %v: %v", trailer, err)
+			}
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("trailer %v: expected 200 got %d", trailer, resp.StatusCode)
+			}
+			if string(body) != "echo: "+bodyContent {
+				t.Errorf("trailer %v: unexpected body '%s'", trailer, body)
+			}
+		}
+		server.Close()
+	}
+}
+
+func TestHMACTransportRejectsForgedResponse(t *testing.T) {
+	for _, trailer := range []bool{false, true} {
+		config := hmacTransportTestConfig(t, trailer)
+
+		// a server signing by another secret of the same key ID
+		forged := config
+		forged.Keyring, _ = NewKeyring("k2", HMACKey{ID: "k2", Secret: []byte("forged")})
+		router := httprouter.New()
+		grp := zin.NewGroup("/", HMACSigner(forged))
+		grp.R(router.POST, "/hmac", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.Write([]byte(bodyContent))
+		})
+		server := httptest.NewServer(router)
+
+		client := &http.Client{Transport: &HMACTransport{Config: config}}
+		resp, err := client.Post(server.URL+"/hmac", "text/plain", strings.NewReader(bodyContent))
+		if err == nil {
+			_, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		if err == nil || !strings.Contains(err.Error(), ErrResponseSignature.Error()) {
+			t.Errorf("trailer %v: expected %v got %v", trailer, ErrResponseSignature, err)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,530 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// HTTP Message Signatures (RFC 9421)
+
+const (
+	signatureInputHeader = "Signature-Input"
+	signatureHeader      = "Signature"
+
+	defaultSignatureLabel = "sig1"
+)
+
+// Algorithms of message signature keys
+const (
+	SignatureHMACSHA256 = "hmac-sha256"
+	SignatureEd25519    = "ed25519"
+)
+
+var (
+	// DefaultRequestComponents are the components covered by request
+	// signatures unless configured
+	DefaultRequestComponents = []string{"@method", "@path", "@authority", "content-digest"}
+
+	// DefaultResponseComponents are the components covered by response
+	// signatures unless configured
+	DefaultResponseComponents = []string{"@status", "content-digest"}
+)
+
+// SignatureKey is a key of message signatures. Secret is the key of
+// hmac-sha256, PrivateKey and PublicKey the keys of ed25519, where verifying
+// needs PublicKey only.
+type SignatureKey struct {
+	ID         string
+	Algorithm  string
+	Secret     []byte
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+}
+
+func (k *SignatureKey) sign(base []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case SignatureHMACSHA256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(base)
+		return mac.Sum(nil), nil
+	case SignatureEd25519:
+		if len(k.PrivateKey) != ed25519.PrivateKeySize {
+			return nil, errors.New("middleware: invalid ed25519 private key")
+		}
+		return ed25519.Sign(k.PrivateKey, base), nil
+	}
+	return nil, fmt.Errorf("middleware: unsupported signature algorithm %q", k.Algorithm)
+}
+
+func (k *SignatureKey) verify(base, signature []byte) bool {
+	switch k.Algorithm {
+	case SignatureHMACSHA256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(base)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case SignatureEd25519:
+		publicKey := k.PublicKey
+		if publicKey == nil && len(k.PrivateKey) == ed25519.PrivateKeySize {
+			publicKey = k.PrivateKey.Public().(ed25519.PublicKey)
+		}
+		return len(publicKey) == ed25519.PublicKeySize && ed25519.Verify(publicKey, base, signature)
+	}
+	return false
+}
+
+// SignatureKeyFunc returns the key of a keyid
+type SignatureKeyFunc func(keyID string) (SignatureKey, bool)
+
+// SignatureKeys returns a SignatureKeyFunc of fixed keys
+func SignatureKeys(keys ...SignatureKey) SignatureKeyFunc {
+	m := make(map[string]SignatureKey, len(keys))
+	for _, key := range keys {
+		m[key.ID] = key
+	}
+	return func(keyID string) (SignatureKey, bool) {
+		key, ok := m[keyID]
+		return key, ok
+	}
+}
+
+// MessageSignatureConfig configures message signatures.
+type MessageSignatureConfig struct {
+	// Label of the signature in Signature-Input and Signature, "sig1" by
+	// default. The verifier checks the first signature if empty.
+	Label string
+
+	// Components covered by signatures, which verified signatures must cover
+	// at least. Derived components are @method, @target-uri, @authority,
+	// @scheme, @request-target, @path and @query for requests and @status for
+	// responses, others are header fields. content-digest is added on signing
+	// if missing.
+	Components []string
+
+	// Key signs messages
+	Key SignatureKey
+
+	// Keys returns the key verifying a signature of keyid, Key alone if nil
+	Keys SignatureKeyFunc
+
+	// MaxAge rejects signatures created longer ago, or without created, if
+	// set
+	MaxAge time.Duration
+
+	// MaxBodySize limits the request bodies read by the verifier to check
+	// Content-Digest, DefaultMaxBodySize if not positive
+	MaxBodySize int64
+}
+
+func (c *MessageSignatureConfig) label() string {
+	if c.Label == "" {
+		return defaultSignatureLabel
+	}
+	return c.Label
+}
+
+func (c *MessageSignatureConfig) components(defaults []string) []string {
+	if c.Components == nil {
+		return defaults
+	}
+	return c.Components
+}
+
+// requestComponent returns the value of a component of r
+func requestComponent(r *http.Request, name string) (string, bool) {
+	switch name {
+	case "@method":
+		return r.Method, true
+	case "@authority":
+		return requestAuthority(r), true
+	case "@scheme":
+		return requestScheme(r), true
+	case "@target-uri":
+		return requestScheme(r) + "://" + requestAuthority(r) + r.URL.RequestURI(), true
+	case "@request-target":
+		return r.URL.RequestURI(), true
+	case "@path":
+		if p := r.URL.EscapedPath(); p != "" {
+			return p, true
+		}
+		return "/", true
+	case "@query":
+		return "?" + r.URL.RawQuery, true
+	}
+	return fieldComponent(r.Header, name)
+}
+
+// responseComponent returns the value of a component of a response
+func responseComponent(header http.Header, status int, name string) (string, bool) {
+	if name == "@status" {
+		return strconv.Itoa(status), true
+	}
+	return fieldComponent(header, name)
+}
+
+func fieldComponent(header http.Header, name string) (string, bool) {
+	if strings.HasPrefix(name, "@") || name != strings.ToLower(name) {
+		return "", false
+	}
+
+	values := header[http.CanonicalHeaderKey(name)]
+	if len(values) == 0 {
+		return "", false
+	}
+	for i, v := range values {
+		values[i] = strings.TrimSpace(v)
+	}
+	return strings.Join(values, ", "), true
+}
+
+func requestAuthority(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	return strings.ToLower(host)
+}
+
+func requestScheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return strings.ToLower(r.URL.Scheme)
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// signatureBase returns the signature base of components valued by value,
+// where params is the serialized signature parameters
+func signatureBase(components []string, value func(string) (string, bool), params string) ([]byte, error) {
+	var b bytes.Buffer
+	for _, name := range components {
+		v, ok := value(name)
+		if !ok {
+			return nil, fmt.Errorf("middleware: missing signature component %q", name)
+		}
+		fmt.Fprintf(&b, "%q: %s\n", name, v)
+	}
+	fmt.Fprintf(&b, "%q: %s", "@signature-params", params)
+	return b.Bytes(), nil
+}
+
+// signatureParams returns the inner list of components with the parameters
+// created and keyid
+func signatureParams(components []string, created time.Time, keyID string) string {
+	return serializeSFInnerList(componentItems(components), []sfParam{
+		{key: "created", value: created.Unix()},
+		{key: "keyid", value: keyID},
+	})
+}
+
+// sign sets Signature-Input and Signature of a message
+func (c *MessageSignatureConfig) sign(header http.Header, components []string, value func(string) (string, bool)) error {
+	params := signatureParams(components, time.Now(), c.Key.ID)
+	base, err := signatureBase(components, value, params)
+	if err != nil {
+		return err
+	}
+
+	signature, err := c.Key.sign(base)
+	if err != nil {
+		return err
+	}
+
+	header.Set(signatureInputHeader, c.label()+"="+params)
+	header.Set(signatureHeader, c.label()+"="+serializeSFBareItem(signature))
+	return nil
+}
+
+// SignRequest signs r, e.g. on the client. Content-Digest of the body is
+// added first if covered and missing.
+func SignRequest(r *http.Request, config MessageSignatureConfig) error {
+	components := config.components(DefaultRequestComponents)
+
+	if containsComponent(components, "content-digest") && r.Header.Get(contentDigestHeader) == "" {
+		body, err := readBody(r)
+		if err != nil {
+			return err
+		}
+		digest, err := contentDigest(bytes.NewReader(body), "sha-256")
+		if err != nil {
+			return err
+		}
+		r.Header.Set(contentDigestHeader, digest)
+	}
+
+	return config.sign(r.Header, components, func(name string) (string, bool) {
+		return requestComponent(r, name)
+	})
+}
+
+func containsComponent(components []string, name string) bool {
+	for _, c := range components {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageSignatureSigner signs responses by config.Key over config.Components,
+// DefaultResponseComponents by default. The response is buffered by
+// BufferWriter to add Content-Digest and the signature headers. Responses
+// without a body, to HEAD or of status 204 and 304, are left unsigned like
+// Envelope leaves them unsealed.
+func MessageSignatureSigner(config MessageSignatureConfig) middleware {
+	components := config.components(DefaultResponseComponents)
+	for _, name := range components {
+		if strings.HasPrefix(name, "@") && name != "@status" {
+			panic(fmt.Sprintf("middleware: unsupported response signature component %q", name))
+		}
+	}
+	if _, err := config.Key.sign(nil); err != nil {
+		panic(err.Error())
+	}
+	digest := containsComponent(components, "content-digest")
+
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			bw := NewBufferWriter(w, 0)
+			defer bw.Close()
+			h(WrapWriter(w, bw), r, p)
+			if bw.Hijacked() {
+				return
+			}
+			if status := bw.Status(); r.Method == http.MethodHead || status == http.StatusNoContent || status == http.StatusNotModified {
+				bw.Commit()
+				return
+			}
+
+			header := bw.Header()
+			var err error
+			if digest && header.Get(contentDigestHeader) == "" {
+				var body io.Reader
+				var value string
+				if body, err = bw.Body(); err == nil {
+					if value, err = contentDigest(body, "sha-256"); err == nil {
+						header.Set(contentDigestHeader, value)
+					}
+				}
+			}
+
+			if err == nil {
+				status := bw.Status()
+				err = config.sign(header, components, func(name string) (string, bool) {
+					return responseComponent(header, status, name)
+				})
+			}
+			if err != nil {
+				// e.g. a component the handler did not set, a server bug
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			bw.Commit()
+		}
+	}
+}
+
+// messageSignature is a signature of Signature-Input and Signature
+type messageSignature struct {
+	components []string
+	params     []sfParam
+	keyID      string
+	created    int64
+	expires    int64
+	signature  []byte
+}
+
+// parseMessageSignature returns the signature of label, or the first one if
+// label is empty
+func parseMessageSignature(header http.Header, label string) (*messageSignature, string) {
+	inputs, err := parseSFDictionary(strings.Join(header[signatureInputHeader], ", "))
+	if err != nil || len(inputs) == 0 {
+		return nil, "missing or malformed signature input"
+	}
+	signatures, err := parseSFDictionary(strings.Join(header[signatureHeader], ", "))
+	if err != nil {
+		return nil, "malformed signature"
+	}
+
+	var input *sfMember
+	for i := range inputs {
+		if inputs[i].key == label || label == "" {
+			input = &inputs[i]
+			break
+		}
+	}
+	if input == nil || !input.isList {
+		return nil, "missing signature input"
+	}
+
+	s := &messageSignature{params: input.item.params}
+	for _, m := range signatures {
+		if m.key == input.key {
+			s.signature, _ = m.item.value.([]byte)
+		}
+	}
+	if s.signature == nil {
+		return nil, "missing signature"
+	}
+
+	for _, item := range input.list {
+		name, ok := item.value.(string)
+		if !ok || len(item.params) > 0 || containsComponent(s.components, name) {
+			return nil, "unsupported signature component"
+		}
+		s.components = append(s.components, name)
+	}
+
+	keyID, _ := input.param("keyid")
+	if s.keyID, _ = keyID.(string); s.keyID == "" {
+		return nil, "missing keyid"
+	}
+	if created, ok := input.param("created"); ok {
+		if s.created, ok = created.(int64); !ok {
+			return nil, "malformed created"
+		}
+	}
+	if expires, ok := input.param("expires"); ok {
+		if s.expires, ok = expires.(int64); !ok {
+			return nil, "malformed expires"
+		}
+	}
+	return s, ""
+}
+
+// MessageSignatureVerifier verifies the signatures of requests, which must
+// cover config.Components, DefaultRequestComponents by default. The keyid
+// selects the key by config.Keys, or config.Key if nil. Content-Digest, if present, must match the
+// body. Requests failing are rejected with 401, or with 413 when the body
+// exceeds config.MaxBodySize, and logged to entry.
+func MessageSignatureVerifier(config MessageSignatureConfig, entry LogEntry) middleware {
+	components := config.components(DefaultRequestComponents)
+	if config.Keys == nil {
+		if config.Key.ID == "" {
+			panic("middleware: message signature verifier without keys")
+		}
+		config.Keys = SignatureKeys(config.Key)
+	}
+
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			fail := func(status int, reason string) {
+				entry.
+					WithField("reason", reason).
+					Warningf("message signature verification failed: %s %s", r.Method, r.URL.String())
+				http.Error(w, http.StatusText(status), status)
+			}
+			reject := func(reason string) {
+				fail(http.StatusUnauthorized, reason)
+			}
+
+			s, reason := parseMessageSignature(r.Header, config.Label)
+			if s == nil {
+				reject(reason)
+				return
+			}
+
+			for _, name := range components {
+				if !containsComponent(s.components, name) {
+					reject("component not covered: " + name)
+					return
+				}
+			}
+
+			key, ok := config.Keys(s.keyID)
+			if !ok {
+				reject("unknown key")
+				return
+			}
+			if alg, ok := findParam(s.params, "alg"); ok && alg != key.Algorithm {
+				reject("algorithm mismatch")
+				return
+			}
+
+			now := time.Now()
+			if s.expires != 0 && now.Unix() > s.expires {
+				reject("signature expired")
+				return
+			}
+			if config.MaxAge > 0 {
+				created := time.Unix(s.created, 0)
+				if s.created == 0 || now.Sub(created) > config.MaxAge || created.Sub(now) > config.MaxAge {
+					reject("signature too old or created in the future")
+					return
+				}
+			}
+
+			if value := r.Header.Get(contentDigestHeader); value != "" {
+				body, err := readLimitedBody(w, r, config.MaxBodySize)
+				if err == errBodyTooLarge {
+					fail(http.StatusRequestEntityTooLarge, "body too large")
+					return
+				} else if err != nil {
+					reject("unreadable body")
+					return
+				}
+				if !verifyContentDigest(value, body) {
+					reject("content digest mismatch")
+					return
+				}
+			}
+
+			params := serializeSFInnerList(componentItems(s.components), s.params)
+			base, err := signatureBase(s.components, func(name string) (string, bool) {
+				return requestComponent(r, name)
+			}, params)
+			if err != nil {
+				reject("missing signature component")
+				return
+			}
+
+			if !key.verify(base, s.signature) {
+				reject("signature mismatch")
+				return
+			}
+
+			h(w, r, p)
+		}
+	}
+}
+
+func componentItems(components []string) []sfItem {
+	items := make([]sfItem, len(components))
+	for i, name := range components {
+		items[i] = sfItem{value: name}
+	}
+	return items
+}
+
+func findParam(params []sfParam, key string) (string, bool) {
+	for _, p := range params {
+		if p.key == key {
+			s, _ := p.value.(string)
+			return s, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// HMACKey is a shared secret of a Keyring. Retired keys are kept known but
+// no longer accepted.
+type HMACKey struct {
+	ID      string
+	Secret  []byte
+	Retired bool
+}
+
+// KeyringProvider returns the keys of a keyring and the ID of the current
+// signing key, e.g. from a secret store
+type KeyringProvider func() (keys []HMACKey, current string, err error)
+
+// Keyring holds the keys of hmac signatures during rotation. Signatures are
+// made with the current key and verified with any key not retired.
+type Keyring struct {
+	provider KeyringProvider
+
+	mutex   sync.RWMutex
+	keys    map[string]HMACKey
+	current string
+}
+
+func NewKeyring(current string, keys ...HMACKey) (*Keyring, error) {
+	k := &Keyring{}
+	if err := k.Set(current, keys...); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// NewKeyringFromProvider returns a keyring loaded from provider, which Reload
+// calls again
+func NewKeyringFromProvider(provider KeyringProvider) (*Keyring, error) {
+	k := &Keyring{provider: provider}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload replaces the keys with the ones of the provider. The keyring is left
+// untouched on error.
+func (k *Keyring) Reload() error {
+	if k.provider == nil {
+		return errors.New("middleware: keyring without provider")
+	}
+
+	keys, current, err := k.provider()
+	if err != nil {
+		return err
+	}
+	return k.Set(current, keys...)
+}
+
+// Set replaces the keys, current must be one of the keys not retired
+func (k *Keyring) Set(current string, keys ...HMACKey) error {
+	m := make(map[string]HMACKey, len(keys))
+	for _, key := range keys {
+		if key.ID == "" {
+			return errors.New("middleware: keyring key without ID")
+		}
+		m[key.ID] = key
+	}
+
+	if key, ok := m[current]; !ok || key.Retired {
+		return fmt.Errorf("middleware: current key %q missing or retired", current)
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.keys, k.current = m, current
+	return nil
+}
+
+// Current returns the signing key
+func (k *Keyring) Current() HMACKey {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.keys[k.current]
+}
+
+// Lookup returns the key of id unless unknown or retired
+func (k *Keyring) Lookup(id string) (HMACKey, bool) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok || key.Retired {
+		return HMACKey{}, false
+	}
+	return key, true
+}
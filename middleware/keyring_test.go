@@ -0,0 +1,131 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	. "github.com/rayark/zin/v2/middleware"
+)
+
+const keyIDHeaderKey = "HMAC-Key-Id"
+
+func keyringSignature(secret string, msg string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(msg))
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
+
+func middlewareKeyringTest(t *testing.T, keyring *Keyring, legacyKeyID, keyID, secret string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/hmac", strings.NewReader(bodyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "" {
+		req.Header.Set(keyIDHeaderKey, keyID)
+	}
+	req.Header.Set(hmacHeaderKey, keyringSignature(secret, "POST\n/hmac\n\n"+bodyContent))
+
+	config := HMACConfig{
+		Algorithm:   HMACSHA256,
+		Header:      hmacHeaderKey,
+		Keyring:     keyring,
+		KeyIDHeader: keyIDHeaderKey,
+		LegacyKeyID: legacyKeyID,
+	}
+
+	router := httprouter.New()
+	grp := zin.NewGroup("/", HMACSigner(config), HMACVerifier(config, newRecordEntry()))
+	grp.R(router.POST, "/hmac", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Write([]byte(bodyContent))
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestKeyringRotation(t *testing.T) {
+	keys := []HMACKey{
+		{ID: "v1", Secret: []byte("old secret")},
+		{ID: "v2", Secret: []byte("new secret")},
+	}
+	current := "v2"
+
+	keyring, err := NewKeyringFromProvider(func() ([]HMACKey, string, error) {
+		return keys, current, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// old builds keep working and get responses signed with their key
+	rec := middlewareKeyringTest(t, keyring, "", "v1", "old secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got '%d'", rec.Code)
+	}
+	if rec.HeaderMap.Get(keyIDHeaderKey) != "v1" || rec.HeaderMap.Get(hmacHeaderKey) != keyringSignature("old secret", bodyContent) {
+		t.Fatalf("expected response signed with v1")
+	}
+
+	rec = middlewareKeyringTest(t, keyring, "", "v2", "old secret")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 got '%d'", rec.Code)
+	}
+
+	// builds predating rotation send no key ID and use the legacy key
+	rec = middlewareKeyringTest(t, keyring, "v1", "", "old secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 without key ID got '%d'", rec.Code)
+	}
+	if rec.HeaderMap.Get(hmacHeaderKey) != keyringSignature("old secret", bodyContent) {
+		t.Fatalf("expected response signed with the legacy key")
+	}
+	rec = middlewareKeyringTest(t, keyring, "", "", "old secret")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without key ID nor legacy key got '%d'", rec.Code)
+	}
+
+	keys = []HMACKey{
+		{ID: "v1", Secret: []byte("old secret"), Retired: true},
+		{ID: "v2", Secret: []byte("new secret")},
+	}
+	if err := keyring.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = middlewareKeyringTest(t, keyring, "", "v1", "old secret")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for retired key got '%d'", rec.Code)
+	}
+
+	rec = middlewareKeyringTest(t, keyring, "", "v2", "new secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got '%d'", rec.Code)
+	}
+
+	current = "v1"
+	if err := keyring.Reload(); err == nil {
+		t.Fatalf("expected error reloading with a retired current key")
+	}
+	if keyring.Current().ID != "v2" {
+		t.Fatalf("expected keyring untouched on error")
+	}
+}
@@ -13,7 +13,6 @@ package middleware
 
 import (
 	"fmt"
-	"net"
 	"net/http"
 	"strconv"
 	"time"
@@ -21,75 +20,215 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
+// LogLevel is the level of a request log
+type LogLevel int
+
+const (
+	LevelInfo LogLevel = iota
+	LevelWarning
+	LevelError
+)
+
+// LogExtractor returns a custom field of the request log, e.g. from the
+// request context, or false to leave it out
+type LogExtractor func(r *http.Request, w *ProxyWriter) (interface{}, bool)
+
+// LoggerConfig configures the fields and the level of request logs
+type LoggerConfig struct {
+	// Fields are the built-in fields logged, DefaultLogFields if nil. The
+	// "variant", "hijacked" and "flushed" fields are only logged when set.
+	Fields []string
+
+	// RequestHeaders, ResponseHeaders and Params are logged as
+	// "header.<name>", "resp_header.<name>" and "param.<name>"
+	RequestHeaders  []string
+	ResponseHeaders []string
+	Params          []string
+
+	// Extractors add custom fields by name
+	Extractors map[string]LogExtractor
+
+	// SlowThreshold marks slower requests, 500ms if zero. RouteSlowThresholds
+	// overrides it by the matched route pattern.
+	SlowThreshold       time.Duration
+	RouteSlowThresholds map[string]time.Duration
+
+	// Level maps the status, 200 if none was written, to the log level,
+	// DefaultLogLevel if nil
+	Level func(status int, slow bool) LogLevel
+
+	// ClientIP resolves the "addr" field unless RealIP wraps Logger, which
+	// otherwise logs the remote address of the connection
+	ClientIP *ClientIPResolver
+}
+
+// DefaultLogFields are the built-in fields of Logger
+var DefaultLogFields = []string{
+	"method", "uri", "route", "addr", "msec", "status", "uagent", "variant",
+	"size", "req_size", "writes", "header_msec", "ttfb_msec", "hijacked", "flushed",
+}
+
+const defaultSlowThreshold = 500 * time.Millisecond
+
+// DefaultLogLevel logs server errors and invalid statuses as errors, client
+// errors and slow requests as warnings and the others as info
+func DefaultLogLevel(status int, slow bool) LogLevel {
+	switch {
+	case status < 100 || status >= 500:
+		return LevelError
+	case status >= 400 || slow:
+		return LevelWarning
+	}
+	return LevelInfo
+}
+
 type LoggerHandler struct {
 	handler http.Handler
 	entry   LogEntry
+	config  LoggerConfig
 }
 
 func LoggerH(h http.Handler, entry LogEntry) LoggerHandler {
-	return LoggerHandler{handler: h, entry: entry}
+	return LoggerHWithConfig(h, LoggerConfig{}, entry)
+}
+
+func LoggerHWithConfig(h http.Handler, config LoggerConfig, entry LogEntry) LoggerHandler {
+	return LoggerHandler{handler: h, entry: entry, config: config}
 }
 
 func (lh LoggerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	proxyWriter := NewProxyWriter(w)
+	proxyWriter.TrackRequestBody(r)
 	t1 := time.Now()
-	lh.handler.ServeHTTP(proxyWriter, r)
+	lh.handler.ServeHTTP(WrapWriter(w, proxyWriter), r)
 	t2 := time.Now()
-	logResult(proxyWriter, r, t2.Sub(t1), lh.entry)
+	lh.config.logResult(proxyWriter, r, nil, t2.Sub(t1), lh.entry)
 }
 
 func Logger(entry LogEntry) func(httprouter.Handle) httprouter.Handle {
+	return LoggerWithConfig(LoggerConfig{}, entry)
+}
+
+func LoggerWithConfig(config LoggerConfig, entry LogEntry) func(httprouter.Handle) httprouter.Handle {
 	return func(h httprouter.Handle) httprouter.Handle {
 		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 			proxyWriter := NewProxyWriter(w)
+			proxyWriter.TrackRequestBody(r)
 			t1 := time.Now()
-			h(proxyWriter, r, p)
+			h(WrapWriter(w, proxyWriter), r, p)
 			t2 := time.Now()
-			logResult(proxyWriter, r, t2.Sub(t1), entry)
+			config.logResult(proxyWriter, r, p, t2.Sub(t1), entry)
 		}
 	}
 }
 
-func findRemoteAddr(r *http.Request) string {
-	addr := r.Header.Get("X-Forwarded-For")
-	if addr == "" {
-		addr, _, _ = net.SplitHostPort(r.RemoteAddr)
+func (c *LoggerConfig) slowThreshold(route string) time.Duration {
+	if d, ok := c.RouteSlowThresholds[route]; ok {
+		return d
 	}
-
-	return addr
+	if c.SlowThreshold > 0 {
+		return c.SlowThreshold
+	}
+	return defaultSlowThreshold
 }
 
-func logResult(proxyWriter *ProxyWriter, r *http.Request, t time.Duration, log LogEntry) {
-	ctx := r.Context()
+// builtinField returns the value of a built-in field, or false to leave it
+// out
+func builtinField(name string, proxyWriter *ProxyWriter, r *http.Request, addr string, status int, t time.Duration) (interface{}, bool) {
+	switch name {
+	case "method":
+		return r.Method, true
+	case "uri":
+		return r.URL.String(), true
+	case "route":
+		route, _ := GetRouteFromContext(r.Context())
+		return route, true
+	case "addr":
+		return addr, true
+	case "msec":
+		return t.Milliseconds(), true
+	case "status":
+		return strconv.Itoa(status), true
+	case "uagent":
+		return r.Header.Get("User-Agent"), true
+	case "variant":
+		return GetVariantFromContext(r.Context())
+	case "size":
+		return proxyWriter.Size(), true
+	case "req_size":
+		return proxyWriter.RequestSize(), true
+	case "writes":
+		return proxyWriter.Writes(), true
+	case "header_msec":
+		return proxyWriter.TimeToHeader().Milliseconds(), true
+	case "ttfb_msec":
+		return proxyWriter.TimeToFirstByte().Milliseconds(), true
+	case "hijacked":
+		return true, proxyWriter.Hijacked()
+	case "flushed":
+		return true, proxyWriter.Flushed()
+	}
+	return nil, false
+}
 
-	method := r.Method
-	uri := r.URL.String()
-	route, _ := GetRouteFromContext(ctx)
-	sourceAddr := findRemoteAddr(r)
-	msec := t.Milliseconds()
+func (c *LoggerConfig) logResult(proxyWriter *ProxyWriter, r *http.Request, p httprouter.Params, t time.Duration, log LogEntry) {
 	status := proxyWriter.Status()
-	uagent := r.Header.Get("User-Agent")
+	if status == 0 {
+		// net/http replies 200 to handlers writing nothing
+		status = http.StatusOK
+	}
 
-	entry := log.
-		WithField("method", method).
-		WithField("uri", uri).
-		WithField("route", route).
-		WithField("addr", sourceAddr).
-		WithField("msec", msec).
-		WithField("status", strconv.Itoa(status)).
-		WithField("uagent", uagent)
+	addr, ok := GetClientIPFromContext(r.Context())
+	if !ok && c.ClientIP != nil {
+		addr = c.ClientIP.Resolve(r)
+	} else if !ok {
+		addr = remoteIP(r)
+	}
 
-	summary := fmt.Sprintf("%d %s %s from %s", status, method, uri, sourceAddr)
+	fields := c.Fields
+	if fields == nil {
+		fields = DefaultLogFields
+	}
 
-	if msec > 500 {
-		summary = summary + fmt.Sprintf(" (%d msec)", msec)
+	entry := log
+	for _, name := range fields {
+		if v, ok := builtinField(name, proxyWriter, r, addr, status, t); ok {
+			entry = entry.WithField(name, v)
+		}
+	}
+	for _, name := range c.RequestHeaders {
+		entry = entry.WithField("header."+name, r.Header.Get(name))
+	}
+	for _, name := range c.ResponseHeaders {
+		entry = entry.WithField("resp_header."+name, proxyWriter.Header().Get(name))
+	}
+	for _, name := range c.Params {
+		entry = entry.WithField("param."+name, p.ByName(name))
+	}
+	for name, extract := range c.Extractors {
+		if v, ok := extract(r, proxyWriter); ok {
+			entry = entry.WithField(name, v)
+		}
 	}
 
-	if status <= 399 && msec <= 500 {
-		entry.Infof(summary)
-	} else if status <= 499 {
-		entry.Warningf(summary)
-	} else if status <= 599 {
-		entry.Errorf(summary)
+	route, _ := GetRouteFromContext(r.Context())
+	slow := t > c.slowThreshold(route)
+
+	summary := fmt.Sprintf("%d %s %s from %s", status, r.Method, r.URL.String(), addr)
+	if slow {
+		summary = summary + fmt.Sprintf(" (%d msec)", t.Milliseconds())
+	}
+
+	level := DefaultLogLevel
+	if c.Level != nil {
+		level = c.Level
+	}
+	switch level(status, slow) {
+	case LevelInfo:
+		entry.Infof("%s", summary)
+	case LevelWarning:
+		entry.Warningf("%s", summary)
+	default:
+		entry.Errorf("%s", summary)
 	}
 }
@@ -0,0 +1,233 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	. "github.com/rayark/zin/v2/middleware"
+)
+
+func middlewareLoggerTest(t *testing.T, logger zin.Middleware, req *http.Request, handle httprouter.Handle) *httptest.ResponseRecorder {
+	router := httprouter.New()
+	grp := zin.NewGroup("/", logger)
+	grp.R(router.POST, "/log/:id", handle)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLoggerMetrics(t *testing.T) {
+	entry := newRecordEntry()
+	req := httptest.NewRequest("POST", "/log/1", strings.NewReader(bodyContent))
+
+	middlewareLoggerTest(t, Logger(entry), req, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		ioutil.ReadAll(r.Body)
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte("a"))
+		w.Write([]byte("bc"))
+		w.(http.Flusher).Flush()
+	})
+
+	expected := map[string]interface{}{
+		"status":   "201",
+		"size":     3,
+		"req_size": int64(len(bodyContent)),
+		"writes":   2,
+		"flushed":  true,
+	}
+	for k, v := range expected {
+		if entry.fields[k] != v {
+			t.Errorf("expected field %s %v got %v", k, v, entry.fields[k])
+		}
+	}
+
+	header, _ := entry.fields["header_msec"].(int64)
+	ttfb, _ := entry.fields["ttfb_msec"].(int64)
+	if header < 5 || ttfb < header+5 {
+		t.Errorf("expected header and first byte times in order got %d %d", header, ttfb)
+	}
+	if _, ok := entry.fields["hijacked"]; ok {
+		t.Error("expected no hijacked field")
+	}
+}
+
+func TestLoggerFlushFirst(t *testing.T) {
+	entry := newRecordEntry()
+	req := httptest.NewRequest("POST", "/log/1", nil)
+
+	// flushing sends the header before any write
+	middlewareLoggerTest(t, Logger(entry), req, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		time.Sleep(5 * time.Millisecond)
+		w.(http.Flusher).Flush()
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte("abc"))
+	})
+
+	if entry.fields["status"] != "200" || entry.fields["flushed"] != true {
+		t.Errorf("expected flushed 200 got %v %v", entry.fields["status"], entry.fields["flushed"])
+	}
+	header, _ := entry.fields["header_msec"].(int64)
+	ttfb, _ := entry.fields["ttfb_msec"].(int64)
+	if header < 5 || ttfb < header+5 {
+		t.Errorf("expected header time at the flush got %d %d", header, ttfb)
+	}
+}
+
+func TestLoggerInformational(t *testing.T) {
+	entry := newRecordEntry()
+	req := httptest.NewRequest("POST", "/log/1", nil)
+
+	middlewareLoggerTest(t, Logger(entry), req, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Header().Set("Link", "</style.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte("x"))
+	})
+
+	// the final status follows the early hints
+	header, _ := entry.fields["header_msec"].(int64)
+	if entry.fields["status"] != "200" || header < 5 {
+		t.Errorf("expected the final 200 logged got %v after %d msec", entry.fields["status"], header)
+	}
+}
+
+func TestLoggerEscapedURI(t *testing.T) {
+	entry := newRecordEntry()
+	req := httptest.NewRequest("POST", "/log/1?q=%41%2F", nil)
+	middlewareLoggerTest(t, Logger(entry), req, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {})
+
+	// the URI is not taken as a format
+	if expected := "200 POST /log/1?q=%41%2F from 192.0.2.1"; len(*entry.logs) != 1 || (*entry.logs)[0] != expected {
+		t.Errorf("expected log '%s' got %v", expected, *entry.logs)
+	}
+}
+
+func TestLoggerLevels(t *testing.T) {
+	cases := []struct {
+		status   int
+		sleep    time.Duration
+		expected LogLevel
+	}{
+		{http.StatusSwitchingProtocols, 0, LevelInfo},
+		{http.StatusOK, 0, LevelInfo},
+		{http.StatusOK, 20 * time.Millisecond, LevelWarning},
+		{http.StatusNotFound, 0, LevelWarning},
+		{http.StatusBadGateway, 0, LevelError},
+		{601, 0, LevelError},
+	}
+
+	for _, c := range cases {
+		var logged []LogLevel
+		config := LoggerConfig{
+			SlowThreshold: 10 * time.Millisecond,
+			Level: func(status int, slow bool) LogLevel {
+				level := DefaultLogLevel(status, slow)
+				logged = append(logged, level)
+				return level
+			},
+		}
+
+		entry := newRecordEntry()
+		req := httptest.NewRequest("POST", "/log/1", nil)
+		middlewareLoggerTest(t, LoggerWithConfig(config, entry), req, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			time.Sleep(c.sleep)
+			w.WriteHeader(c.status)
+		})
+
+		if len(*entry.logs) != 1 || len(logged) != 1 || logged[0] != c.expected {
+			t.Errorf("%d after %v: expected one log of level %d got %v %v", c.status, c.sleep, c.expected, logged, *entry.logs)
+		}
+	}
+}
+
+func TestLoggerRouteSlowThreshold(t *testing.T) {
+	config := LoggerConfig{
+		SlowThreshold:       time.Millisecond,
+		RouteSlowThresholds: map[string]time.Duration{"/log/:id": time.Hour},
+		Level: func(status int, slow bool) LogLevel {
+			if slow {
+				t.Error("expected the route threshold to apply")
+			}
+			return LevelInfo
+		},
+	}
+
+	req := httptest.NewRequest("POST", "/log/1", nil)
+	middlewareLoggerTest(t, LoggerWithConfig(config, newRecordEntry()), req, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		time.Sleep(5 * time.Millisecond)
+	})
+}
+
+type loggerTestKey struct{}
+
+func TestLoggerFields(t *testing.T) {
+	config := LoggerConfig{
+		Fields:          []string{"method", "status"},
+		RequestHeaders:  []string{"X-Request-ID"},
+		ResponseHeaders: []string{"Content-Type"},
+		Params:          []string{"id"},
+		Extractors: map[string]LogExtractor{
+			"player": func(r *http.Request, w *ProxyWriter) (interface{}, bool) {
+				v := r.Context().Value(loggerTestKey{})
+				return v, v != nil
+			},
+			"missing": func(r *http.Request, w *ProxyWriter) (interface{}, bool) {
+				return nil, false
+			},
+		},
+	}
+
+	entry := newRecordEntry()
+	req := httptest.NewRequest("POST", "/log/42", nil)
+	req.Header.Set("X-Request-ID", "abc")
+
+	router := httprouter.New()
+	setPlayer := func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			h(w, r.WithContext(context.WithValue(r.Context(), loggerTestKey{}, "p1")), p)
+		}
+	}
+	grp := zin.NewGroup("/", LoggerWithConfig(config, entry), setPlayer)
+	grp.R(router.POST, "/log/:id", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Header().Set("Content-Type", "text/plain")
+	})
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	expected := map[string]interface{}{
+		"method":                   "POST",
+		"status":                   "200",
+		"header.X-Request-ID":      "abc",
+		"resp_header.Content-Type": "text/plain",
+		"param.id":                 "42",
+		"player":                   "p1",
+	}
+	if len(entry.fields) != len(expected) {
+		t.Errorf("expected fields %v got %v", expected, entry.fields)
+	}
+	for k, v := range expected {
+		if entry.fields[k] != v {
+			t.Errorf("expected field %s '%v' got '%v'", k, v, entry.fields[k])
+		}
+	}
+}
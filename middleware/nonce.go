@@ -0,0 +1,64 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// NonceStore tracks the nonces of verified requests to reject replays. Stores
+// shared by several instances, e.g. on Redis, must check and record
+// atomically.
+type NonceStore interface {
+	// Use records the nonce until expiry and reports false if it is
+	// already recorded
+	Use(nonce string, expiry time.Time) bool
+}
+
+const nonceStorePurgeInterval = time.Minute
+
+// MemoryNonceStore is a NonceStore of a single instance, expired nonces are
+// purged at most once a minute
+type MemoryNonceStore struct {
+	mutex     sync.Mutex
+	nonces    map[string]time.Time
+	nextPurge time.Time
+}
+
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{
+		nonces: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryNonceStore) Use(nonce string, expiry time.Time) bool {
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if now.After(s.nextPurge) {
+		for n, e := range s.nonces {
+			if now.After(e) {
+				delete(s.nonces, n)
+			}
+		}
+		s.nextPurge = now.Add(nonceStorePurgeInterval)
+	}
+
+	if e, ok := s.nonces[nonce]; ok && !now.After(e) {
+		return false
+	}
+	s.nonces[nonce] = expiry
+	return true
+}
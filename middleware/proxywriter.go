@@ -13,21 +13,37 @@ package middleware
 
 import (
 	"bufio"
+	"io"
 	"net"
 	"net/http"
+	"time"
 )
 
+// ProxyWriter records the status, the size and the timing of a response for
+// Logger and metrics middlewares
 type ProxyWriter struct {
 	http.ResponseWriter
 	status int
 	size   int
+
+	start      time.Time
+	headerTime time.Time
+	firstByte  time.Time
+	writes     int
+	hijacked   bool
+	flushed    bool
+
+	requestBody *countingBody
 }
 
 func NewProxyWriter(w http.ResponseWriter) *ProxyWriter {
 	return &ProxyWriter{
 		ResponseWriter: w,
+		start:          time.Now(),
 	}
 }
+
+// Status returns the final status, 0 if none was written
 func (w *ProxyWriter) Status() int {
 	return w.status
 }
@@ -36,21 +52,146 @@ func (w *ProxyWriter) Size() int {
 	return w.size
 }
 
+// TimeToHeader returns the time from NewProxyWriter to the write of the
+// final response header, 0 if not written
+func (w *ProxyWriter) TimeToHeader() time.Duration {
+	if w.headerTime.IsZero() {
+		return 0
+	}
+	return w.headerTime.Sub(w.start)
+}
+
+// TimeToFirstByte returns the time from NewProxyWriter to the first byte of
+// the body, 0 if none was written
+func (w *ProxyWriter) TimeToFirstByte() time.Duration {
+	if w.firstByte.IsZero() {
+		return 0
+	}
+	return w.firstByte.Sub(w.start)
+}
+
+// Writes returns the number of Write and ReadFrom calls
+func (w *ProxyWriter) Writes() int {
+	return w.writes
+}
+
+func (w *ProxyWriter) Hijacked() bool {
+	return w.hijacked
+}
+
+func (w *ProxyWriter) Flushed() bool {
+	return w.flushed
+}
+
+// TrackRequestBody wraps the body of r to count the bytes the handler reads
+func (w *ProxyWriter) TrackRequestBody(r *http.Request) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	w.requestBody = &countingBody{ReadCloser: r.Body}
+	r.Body = w.requestBody
+}
+
+// RequestSize returns the bytes read from the body of the request of
+// TrackRequestBody
+func (w *ProxyWriter) RequestSize() int64 {
+	if w.requestBody == nil {
+		return 0
+	}
+	return w.requestBody.n
+}
+
 func (w *ProxyWriter) WriteHeader(s int) {
-	w.status = s
+	w.recordHeader(s)
 	w.ResponseWriter.WriteHeader(s)
 }
 
+// recordHeader records the status and the time of the final response header,
+// also sent implicitly by Flush or taken over by Hijack. Informational 1xx
+// headers precede the final one and are not recorded.
+func (w *ProxyWriter) recordHeader(s int) {
+	if w.status != 0 || (s >= 100 && s < 200 && s != http.StatusSwitchingProtocols) {
+		return
+	}
+	w.status = s
+	w.headerTime = time.Now()
+}
+
 func (w *ProxyWriter) Write(b []byte) (int, error) {
 	if w.status == 0 {
 		// The status will be StatusOK if WriteHeader has not been called yet
 		w.WriteHeader(http.StatusOK)
 	}
+	w.writes++
 	size, err := w.ResponseWriter.Write(b)
-	w.size += size
+	w.count(size)
 	return size, err
 }
 
 func (w *ProxyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	return w.ResponseWriter.(http.Hijacker).Hijack()
+	conn, rw, err := hijack(w.ResponseWriter)
+	if err == nil {
+		w.hijacked = true
+		if w.status == 0 {
+			w.recordHeader(http.StatusSwitchingProtocols)
+		}
+	}
+	return conn, rw, err
+}
+
+func (w *ProxyWriter) Flush() {
+	if w.status == 0 {
+		// the underlying writer sends StatusOK on the first flush
+		w.recordHeader(http.StatusOK)
+	}
+	w.flushed = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ReadFrom counts the bytes of src, handed to the underlying io.ReaderFrom if
+// any so that sendfile still applies
+func (w *ProxyWriter) ReadFrom(src io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	w.writes++
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(writerOnly{w.ResponseWriter}, src)
+	}
+	w.count(int(n))
+	return n, err
+}
+
+func (w *ProxyWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+func (w *ProxyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *ProxyWriter) count(n int) {
+	if n > 0 && w.firstByte.IsZero() {
+		w.firstByte = time.Now()
+	}
+	w.size += n
+}
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
 }
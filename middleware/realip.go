@@ -0,0 +1,166 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// ClientIPResolver resolves the IP of the client behind trusted proxies.
+// Forwarding headers are only believed when set by a trusted proxy: the
+// addresses they list are walked from the right, skipping trusted proxies,
+// and the first untrusted one is the client. Clients can prepend anything to
+// the headers, but not past the last trusted hop.
+//
+// Only the Header set by the trusted proxies is read, any other forwarding
+// header being passed through from the client as is.
+type ClientIPResolver struct {
+	// Header is the forwarding header set by the trusted proxies,
+	// "X-Forwarded-For" if empty. "Forwarded" (RFC 7239) is read by its "for"
+	// parameters and other headers, e.g. "X-Real-IP", as lists of addresses.
+	Header string
+
+	trusted []*net.IPNet
+}
+
+// NewClientIPResolver returns a resolver trusting the proxies of cidrs, e.g.
+// "10.0.0.0/8", where a single IP stands for its own host
+func NewClientIPResolver(cidrs ...string) (*ClientIPResolver, error) {
+	c := &ClientIPResolver{}
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("middleware: invalid trusted proxy %q: %v", cidr, err)
+		}
+		c.trusted = append(c.trusted, n)
+	}
+	return c, nil
+}
+
+func (c *ClientIPResolver) isTrusted(ip net.IP) bool {
+	for _, n := range c.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client IP of r by the configured Header
+func (c *ClientIPResolver) Resolve(r *http.Request) string {
+	remote := remoteIP(r)
+	ip := net.ParseIP(remote)
+	if ip == nil || !c.isTrusted(ip) {
+		return remote
+	}
+
+	header := c.Header
+	if header == "" {
+		header = "X-Forwarded-For"
+	}
+
+	var hops []string
+	values := r.Header[http.CanonicalHeaderKey(header)]
+	if http.CanonicalHeaderKey(header) == "Forwarded" {
+		hops = forwardedFor(values)
+	} else {
+		for _, v := range values {
+			for _, hop := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(stripPort(hops[i]))
+		if ip == nil {
+			// unknown or obfuscated hops end what can be relied on, the
+			// last hop seen is the closest to the client
+			break
+		}
+		client = ip.String()
+		if !c.isTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedFor returns the "for" parameters of the Forwarded header values
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			hop := "unknown"
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					hop = strings.Trim(kv[1], `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// stripPort removes the port of "ip:port" and "[ipv6]:port" and the
+// brackets of "[ipv6]"
+func stripPort(hop string) string {
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientIP returns the client IP stored by RealIP, or the remote address of
+// the connection
+func ClientIP(r *http.Request) string {
+	if ip, ok := GetClientIPFromContext(r.Context()); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// RealIP stores the client IP resolved by resolver in the request context,
+// for ClientIP, Logger and other middlewares inside it
+func RealIP(resolver *ClientIPResolver) middleware {
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			ctx := context.WithValue(r.Context(), ClientIPKey, resolver.Resolve(r))
+			h(w, r.WithContext(ctx), p)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	. "github.com/rayark/zin/v2/middleware"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := NewClientIPResolver("10.0.0.0/8", "192.168.1.1", "2001:db8:1::/48")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		header   string
+		remote   string
+		headers  map[string]string
+		expected string
+	}{
+		// untrusted peers cannot forward
+		{"", "203.0.113.9:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.9"},
+		{"", "10.0.0.1:1234", nil, "10.0.0.1"},
+		// a spoofed leftmost entry is ignored
+		{"", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7, 10.1.1.1"}, "198.51.100.7"},
+		{"", "192.168.1.1:1234", map[string]string{"X-Forwarded-For": "10.2.2.2, 10.1.1.1"}, "10.2.2.2"},
+		{"", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "garbage, 10.1.1.1"}, "10.1.1.1"},
+		// headers other than the configured one are passed through from
+		// the client, never believed
+		{"", "10.0.0.1:1234", map[string]string{
+			"X-Forwarded-For": "203.0.113.7",
+			"Forwarded":       "for=1.2.3.4",
+		}, "203.0.113.7"},
+		{"", "10.0.0.1:1234", map[string]string{"X-Real-IP": "198.51.100.7"}, "10.0.0.1"},
+		{"X-Real-IP", "10.0.0.1:1234", map[string]string{"X-Real-IP": "198.51.100.7"}, "198.51.100.7"},
+		{"Forwarded", "10.0.0.1:1234", map[string]string{
+			"Forwarded":       `for=6.6.6.6, for="[2001:db8:cafe::17]:4711";proto=https, for=10.1.1.1`,
+			"X-Forwarded-For": "6.6.6.6",
+		}, "2001:db8:cafe::17"},
+		{"Forwarded", "10.0.0.1:1234", map[string]string{"Forwarded": `for="198.51.100.7:80";by=10.1.1.1`}, "198.51.100.7"},
+		{"Forwarded", "10.0.0.1:1234", map[string]string{"Forwarded": `for=198.51.100.7, for=_hidden`}, "10.0.0.1"},
+		{"Forwarded", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "10.0.0.1"},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = c.remote
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+
+		resolver.Header = c.header
+		if ip := resolver.Resolve(req); ip != c.expected {
+			t.Errorf("%s %s %v: expected %s got %s", c.header, c.remote, c.headers, c.expected, ip)
+		}
+	}
+
+	if _, err := NewClientIPResolver("10.0.0.0/33"); err == nil {
+		t.Error("expected invalid CIDR rejected")
+	}
+}
+
+func TestRealIPLogger(t *testing.T) {
+	resolver, _ := NewClientIPResolver("10.0.0.0/8")
+
+	var clientIP string
+	entry := newRecordEntry()
+	router := httprouter.New()
+	// the first middleware is the innermost, RealIP wraps Logger
+	grp := zin.NewGroup("/", Logger(entry), RealIP(resolver))
+	grp.R(router.GET, "/ip", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		clientIP = ClientIP(r)
+	})
+
+	req := httptest.NewRequest("GET", "/ip", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "6.6.6.6, 198.51.100.7")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if clientIP != "198.51.100.7" {
+		t.Errorf("expected client IP in context got '%s'", clientIP)
+	}
+	if entry.fields["addr"] != "198.51.100.7" {
+		t.Errorf("expected logged addr got '%v'", entry.fields["addr"])
+	}
+
+	// Logger outside RealIP resolves by its own config
+	entry = newRecordEntry()
+	router = httprouter.New()
+	grp = zin.NewGroup("/", LoggerWithConfig(LoggerConfig{ClientIP: resolver}, entry))
+	grp.R(router.GET, "/ip", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {})
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if entry.fields["addr"] != "198.51.100.7" {
+		t.Errorf("expected addr resolved by config got '%v'", entry.fields["addr"])
+	}
+
+	// without a resolver forwarding headers are not believed
+	entry = newRecordEntry()
+	router = httprouter.New()
+	grp = zin.NewGroup("/", Logger(entry))
+	grp.R(router.GET, "/ip", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {})
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if entry.fields["addr"] != "10.0.0.1" {
+		t.Errorf("expected remote addr got '%v'", entry.fields["addr"])
+	}
+}
@@ -0,0 +1,185 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	ErrURLExpired   = errors.New("middleware: signed url expired")
+	ErrURLSignature = errors.New("middleware: invalid url signature")
+)
+
+const (
+	defaultExpiresParam   = "expires"
+	defaultSignatureParam = "signature"
+)
+
+// URLSigner signs URLs with an expiry, e.g. of temporary download links. The
+// signature covers the path and the query, canonicalized so that reordering
+// or re-encoding the parameters keeps the signature valid.
+type URLSigner struct {
+	// Algorithm is HMACSHA256 if zero
+	Algorithm HMACAlgorithm
+	Secret    []byte
+
+	// ExpiresParam and SignatureParam are the query parameters of the expiry
+	// in unix seconds and of the base64url signature, "expires" and
+	// "signature" if empty
+	ExpiresParam   string
+	SignatureParam string
+}
+
+func (s *URLSigner) algorithm() HMACAlgorithm {
+	if s.Algorithm.New == nil {
+		return HMACSHA256
+	}
+	return s.Algorithm
+}
+
+func (s *URLSigner) expiresParam() string {
+	if s.ExpiresParam == "" {
+		return defaultExpiresParam
+	}
+	return s.ExpiresParam
+}
+
+func (s *URLSigner) signatureParam() string {
+	if s.SignatureParam == "" {
+		return defaultSignatureParam
+	}
+	return s.SignatureParam
+}
+
+func (s *URLSigner) mac(path string, query url.Values) []byte {
+	h := hmac.New(s.algorithm().New, s.Secret)
+	h.Write([]byte(path + "?" + canonicalQuery(query, s.signatureParam())))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key then value, without exclude
+func canonicalQuery(query url.Values, exclude string) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if k != exclude {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Sign returns u with the expiry and the signature added to its query
+func (s *URLSigner) Sign(u *url.URL, expiry time.Time) *url.URL {
+	signed := *u
+	query := u.Query()
+	query.Set(s.expiresParam(), strconv.FormatInt(expiry.Unix(), 10))
+	query.Del(s.signatureParam())
+	query.Set(s.signatureParam(), EncodingBase64URL.encode(s.mac(u.EscapedPath(), query)))
+	signed.RawQuery = query.Encode()
+	return &signed
+}
+
+// SignRoute returns the signed path and query of the route pattern, e.g.
+// "/replays/:id/*file", with its params substituted
+func (s *URLSigner) SignRoute(pattern string, params httprouter.Params, query url.Values, expiry time.Time) (string, error) {
+	p, err := routePath(pattern, params)
+	if err != nil {
+		return "", err
+	}
+	u := &url.URL{Path: p, RawQuery: query.Encode()}
+	return s.Sign(u, expiry).String(), nil
+}
+
+// routePath substitutes the :name and *name segments of pattern by params
+func routePath(pattern string, params httprouter.Params) (string, error) {
+	segments := strings.Split(pattern, "/")
+	for i, seg := range segments {
+		if seg == "" || (seg[0] != ':' && seg[0] != '*') {
+			continue
+		}
+
+		value := params.ByName(seg[1:])
+		if value == "" {
+			return "", fmt.Errorf("middleware: missing route param %q", seg[1:])
+		}
+		if seg[0] == '*' {
+			// catch-all params keep their slashes, httprouter includes the
+			// leading one
+			segments[i] = strings.TrimPrefix(value, "/")
+		} else {
+			segments[i] = value
+		}
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// Verify returns ErrURLSignature unless u carries a valid signature, and
+// ErrURLExpired if its expiry has passed
+func (s *URLSigner) Verify(u *url.URL) error {
+	query := u.Query()
+
+	signature, err := EncodingBase64URL.decode(query.Get(s.signatureParam()))
+	if err != nil || len(signature) == 0 {
+		return ErrURLSignature
+	}
+	if !hmac.Equal(signature, s.mac(u.EscapedPath(), query)) {
+		return ErrURLSignature
+	}
+
+	expires, err := strconv.ParseInt(query.Get(s.expiresParam()), 10, 64)
+	if err != nil {
+		return ErrURLSignature
+	}
+	if time.Now().Unix() > expires {
+		return ErrURLExpired
+	}
+	return nil
+}
+
+// SignedURLVerifier returns a middleware wrapper rejecting requests with 403
+// unless their URL is signed by signer and not expired. The reason of
+// rejections is logged to entry.
+func SignedURLVerifier(signer *URLSigner, entry LogEntry) middleware {
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			if err := signer.Verify(r.URL); err != nil {
+				entry.
+					WithField("reason", err.Error()).
+					Warningf("signed url rejected: %s %s", r.Method, r.URL.Path)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			h(w, r, p)
+		}
+	}
+}
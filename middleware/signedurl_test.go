@@ -0,0 +1,105 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	. "github.com/rayark/zin/v2/middleware"
+)
+
+func middlewareSignedURLTest(t *testing.T, signer *URLSigner, target string) (*httptest.ResponseRecorder, recordEntry) {
+	entry := newRecordEntry()
+	router := httprouter.New()
+	grp := zin.NewGroup("/", SignedURLVerifier(signer, entry))
+	grp.R(router.GET, "/replays/:id/*file", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Write([]byte(p.ByName("id") + p.ByName("file")))
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	return rec, entry
+}
+
+func TestSignedURL(t *testing.T) {
+	signer := &URLSigner{Secret: []byte(secretString)}
+	params := httprouter.Params{{Key: "id", Value: "42"}, {Key: "file", Value: "/screens/a.png"}}
+	query := url.Values{"size": {"large"}, "b": {"2", "1"}}
+
+	target, err := signer.SignRoute("/replays/:id/*file", params, query, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(target, "/replays/42/screens/a.png?") {
+		t.Fatalf("unexpected signed url '%s'", target)
+	}
+
+	rec, entry := middlewareSignedURLTest(t, signer, target)
+	if rec.Code != http.StatusOK || rec.Body.String() != "42/screens/a.png" {
+		t.Fatalf("expected 200 got %d %v", rec.Code, entry.fields)
+	}
+
+	// reordering the query keeps the signature valid
+	u, _ := url.Parse(target)
+	parts := strings.Split(u.RawQuery, "&")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	rec, entry = middlewareSignedURLTest(t, signer, u.Path+"?"+strings.Join(parts, "&"))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected reordered query accepted got %d %v", rec.Code, entry.fields)
+	}
+
+	tampered := []string{
+		strings.Replace(target, "/42/", "/43/", 1),
+		strings.Replace(target, "size=large", "size=huge", 1),
+		target + "&extra=1",
+		u.Path,
+	}
+	for _, target := range tampered {
+		rec, entry = middlewareSignedURLTest(t, signer, target)
+		if rec.Code != http.StatusForbidden || entry.fields["reason"] != ErrURLSignature.Error() {
+			t.Errorf("%s: expected 403 got %d %v", target, rec.Code, entry.fields)
+		}
+	}
+
+	other := &URLSigner{Secret: []byte("other secret")}
+	if rec, _ = middlewareSignedURLTest(t, other, target); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for another secret got %d", rec.Code)
+	}
+}
+
+func TestSignedURLExpired(t *testing.T) {
+	signer := &URLSigner{Secret: []byte(secretString)}
+	params := httprouter.Params{{Key: "id", Value: "42"}, {Key: "file", Value: "/a.png"}}
+
+	target, err := signer.SignRoute("/replays/:id/*file", params, nil, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, entry := middlewareSignedURLTest(t, signer, target)
+	if rec.Code != http.StatusForbidden || entry.fields["reason"] != ErrURLExpired.Error() {
+		t.Errorf("expected 403 expired got %d %v", rec.Code, entry.fields)
+	}
+
+	if _, err := signer.SignRoute("/replays/:id/*file", nil, nil, time.Now()); err == nil {
+		t.Error("expected error of missing route params")
+	}
+}
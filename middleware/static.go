@@ -0,0 +1,132 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// precompressedSuffixes are the file suffixes of precompressed variants in
+// order of server preference
+var precompressedSuffixes = []struct {
+	coding string
+	suffix string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// StaticFiles returns a handle serving the files of root by the `filepath`
+// param, like httprouter.Router.ServeFiles. When the client accepts it, the
+// precompressed foo.js.br or foo.js.gz next to foo.js is served instead with
+// the Content-Type of foo.js, which Compressor leaves untouched.
+func StaticFiles(root http.FileSystem) httprouter.Handle {
+	fileServer := http.FileServer(root)
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		name := path.Clean("/" + p.ByName("filepath"))
+		if strings.HasSuffix(p.ByName("filepath"), "/") && name != "/" {
+			name += "/"
+		}
+
+		if !strings.HasSuffix(name, "/") && serveVariant(w, r, root, name) {
+			return
+		}
+
+		r.URL.Path = name
+		fileServer.ServeHTTP(w, r)
+	}
+}
+
+func serveVariant(w http.ResponseWriter, r *http.Request, root http.FileSystem, name string) bool {
+	if !fileExists(root, name) {
+		return false
+	}
+
+	var codings []string
+	for _, v := range precompressedSuffixes {
+		if fileExists(root, name+v.suffix) {
+			codings = append(codings, v.coding)
+		}
+	}
+	if len(codings) == 0 {
+		return false
+	}
+
+	addVary(w.Header(), "Accept-Encoding")
+
+	coding, ok := negotiateEncoding(r.Header, codings)
+	if !ok || coding == identityEncoding {
+		return false
+	}
+
+	suffix := ""
+	for _, v := range precompressedSuffixes {
+		if v.coding == coding {
+			suffix = v.suffix
+		}
+	}
+
+	f, err := root.Open(name + suffix)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = sniffContentType(root, name)
+	}
+	if contentType == "" {
+		return false
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Encoding", coding)
+	http.ServeContent(w, r, name, stat.ModTime(), f)
+	return true
+}
+
+func fileExists(root http.FileSystem, name string) bool {
+	f, err := root.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	return err == nil && !stat.IsDir()
+}
+
+// sniffContentType detects the Content-Type of the uncompressed file
+func sniffContentType(root http.FileSystem, name string) string {
+	f, err := root.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(f, buf)
+	return http.DetectContentType(buf[:n])
+}
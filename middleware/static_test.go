@@ -0,0 +1,93 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	. "github.com/rayark/zin/v2/middleware"
+)
+
+func middlewareStaticTest(t *testing.T, dir string, file string, acceptEncoding string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/static/"+file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
+	router := httprouter.New()
+	grp := zin.NewGroup("/", Compressor)
+	grp.ServeFiles(router.GET, "/static/*filepath", http.Dir(dir))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPrecompressedStatic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zin-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := strings.Repeat("console.log('zin');\n", 200)
+
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write([]byte(content))
+	gzw.Close()
+
+	files := map[string][]byte{
+		"app.js":    []byte(content),
+		"app.js.gz": gz.Bytes(),
+		"app.js.br": []byte("fake brotli"),
+	}
+	for name, b := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := middlewareStaticTest(t, dir, "app.js", "gzip, deflate, br")
+	if rec.HeaderMap.Get("Content-Encoding") != "br" || rec.Body.String() != "fake brotli" {
+		t.Fatalf("expected brotli variant got '%s'", rec.HeaderMap.Get("Content-Encoding"))
+	}
+	if !strings.HasPrefix(rec.HeaderMap.Get("Content-Type"), "text/javascript") {
+		t.Fatalf("expected Content-Type of app.js got '%s'", rec.HeaderMap.Get("Content-Type"))
+	}
+	if rec.HeaderMap.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected Vary: Accept-Encoding got '%s'", rec.HeaderMap["Vary"])
+	}
+
+	rec = middlewareStaticTest(t, dir, "app.js", "gzip")
+	if rec.HeaderMap.Get("Content-Encoding") != "gzip" || !bytes.Equal(rec.Body.Bytes(), gz.Bytes()) {
+		t.Fatalf("expected gzip variant served as is")
+	}
+
+	rec = middlewareStaticTest(t, dir, "app.js", "")
+	if rec.HeaderMap.Get("Content-Encoding") != "" || rec.Body.String() != content {
+		t.Fatalf("expected original file")
+	}
+}
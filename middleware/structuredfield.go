@@ -0,0 +1,303 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+// The subset of Structured Field Values (RFC 8941) used by HTTP Message
+// Signatures and Content-Digest: dictionaries whose members are inner lists
+// or bare items, with parameters. Decimals are not supported.
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errStructuredField = errors.New("middleware: malformed structured field")
+
+type sfToken string
+
+type sfParam struct {
+	key   string
+	value interface{}
+}
+
+type sfItem struct {
+	value  interface{}
+	params []sfParam
+}
+
+type sfMember struct {
+	key    string
+	item   sfItem
+	list   []sfItem
+	isList bool
+}
+
+func (m *sfMember) param(key string) (interface{}, bool) {
+	for _, p := range m.item.params {
+		if p.key == key {
+			return p.value, true
+		}
+	}
+	return nil, false
+}
+
+type sfParser struct {
+	s string
+	i int
+}
+
+func parseSFDictionary(s string) ([]sfMember, error) {
+	p := &sfParser{s: s}
+	var members []sfMember
+
+	p.skipSP()
+	for p.i < len(p.s) {
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+
+		m := sfMember{key: key}
+		if p.peek() == '=' {
+			p.i++
+			if p.peek() == '(' {
+				m.isList = true
+				if m.list, err = p.innerList(); err != nil {
+					return nil, err
+				}
+				if m.item.params, err = p.params(); err != nil {
+					return nil, err
+				}
+			} else if m.item, err = p.item(); err != nil {
+				return nil, err
+			}
+		} else {
+			m.item.value = true
+			if m.item.params, err = p.params(); err != nil {
+				return nil, err
+			}
+		}
+		members = append(members, m)
+
+		p.skipOWS()
+		if p.i >= len(p.s) {
+			break
+		}
+		if p.s[p.i] != ',' {
+			return nil, errStructuredField
+		}
+		p.i++
+		p.skipOWS()
+		if p.i >= len(p.s) {
+			return nil, errStructuredField
+		}
+	}
+	return members, nil
+}
+
+func (p *sfParser) peek() byte {
+	if p.i < len(p.s) {
+		return p.s[p.i]
+	}
+	return 0
+}
+
+func (p *sfParser) skipSP() {
+	for p.i < len(p.s) && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+func (p *sfParser) key() (string, error) {
+	start := p.i
+	c := p.peek()
+	if !(c >= 'a' && c <= 'z') && c != '*' {
+		return "", errStructuredField
+	}
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '-' || c == '.' || c == '*' {
+			p.i++
+			continue
+		}
+		break
+	}
+	return p.s[start:p.i], nil
+}
+
+func (p *sfParser) innerList() ([]sfItem, error) {
+	p.i++ // (
+	var items []sfItem
+	for {
+		p.skipSP()
+		if p.peek() == ')' {
+			p.i++
+			return items, nil
+		}
+		if p.i >= len(p.s) {
+			return nil, errStructuredField
+		}
+		item, err := p.item()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return nil, errStructuredField
+		}
+	}
+}
+
+func (p *sfParser) item() (sfItem, error) {
+	value, err := p.bareItem()
+	if err != nil {
+		return sfItem{}, err
+	}
+	params, err := p.params()
+	if err != nil {
+		return sfItem{}, err
+	}
+	return sfItem{value: value, params: params}, nil
+}
+
+func (p *sfParser) params() ([]sfParam, error) {
+	var params []sfParam
+	for p.peek() == ';' {
+		p.i++
+		p.skipSP()
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var value interface{} = true
+		if p.peek() == '=' {
+			p.i++
+			if value, err = p.bareItem(); err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, sfParam{key: key, value: value})
+	}
+	return params, nil
+}
+
+func (p *sfParser) bareItem() (interface{}, error) {
+	c := p.peek()
+	switch {
+	case c == '"':
+		return p.string()
+	case c == ':':
+		return p.byteSequence()
+	case c == '?':
+		if p.i+1 < len(p.s) && (p.s[p.i+1] == '0' || p.s[p.i+1] == '1') {
+			p.i += 2
+			return p.s[p.i-1] == '1', nil
+		}
+		return nil, errStructuredField
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.i
+		p.i++
+		for p.i < len(p.s) && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+			p.i++
+		}
+		return strconv.ParseInt(p.s[start:p.i], 10, 64)
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '*':
+		start := p.i
+		for p.i < len(p.s) && !strings.ContainsRune(" ;,()=\"\t", rune(p.s[p.i])) {
+			p.i++
+		}
+		return sfToken(p.s[start:p.i]), nil
+	}
+	return nil, errStructuredField
+}
+
+func (p *sfParser) string() (string, error) {
+	p.i++ // "
+	var b strings.Builder
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '\\':
+			if p.i >= len(p.s) || (p.s[p.i] != '"' && p.s[p.i] != '\\') {
+				return "", errStructuredField
+			}
+			b.WriteByte(p.s[p.i])
+			p.i++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", errStructuredField
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errStructuredField
+}
+
+func (p *sfParser) byteSequence() ([]byte, error) {
+	p.i++ // :
+	end := strings.IndexByte(p.s[p.i:], ':')
+	if end < 0 {
+		return nil, errStructuredField
+	}
+	b, err := base64.StdEncoding.DecodeString(p.s[p.i : p.i+end])
+	p.i += end + 1
+	return b, err
+}
+
+func serializeSFBareItem(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		v = strings.Replace(v, `\`, `\\`, -1)
+		return `"` + strings.Replace(v, `"`, `\"`, -1) + `"`
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case []byte:
+		return ":" + base64.StdEncoding.EncodeToString(v) + ":"
+	case bool:
+		if v {
+			return "?1"
+		}
+		return "?0"
+	case sfToken:
+		return string(v)
+	}
+	return ""
+}
+
+func serializeSFParams(params []sfParam) string {
+	var b strings.Builder
+	for _, p := range params {
+		b.WriteString(";" + p.key)
+		if v, ok := p.value.(bool); !ok || !v {
+			b.WriteString("=" + serializeSFBareItem(p.value))
+		}
+	}
+	return b.String()
+}
+
+func serializeSFInnerList(items []sfItem, params []sfParam) string {
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = serializeSFBareItem(item.value) + serializeSFParams(item.params)
+	}
+	return "(" + strings.Join(parts, " ") + ")" + serializeSFParams(params)
+}
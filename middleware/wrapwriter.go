@@ -0,0 +1,188 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware
+
+import (
+	"io"
+	"net/http"
+)
+
+type writerUnwrapper interface {
+	Unwrap() http.ResponseWriter
+}
+
+type unwrapper struct {
+	w http.ResponseWriter
+}
+
+func (u unwrapper) Unwrap() http.ResponseWriter {
+	return u.w
+}
+
+// WrapWriter returns wrapper narrowed down to the optional interfaces among
+// http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom that both w and
+// wrapper implement, so that handlers asserting them see the capabilities of
+// the actual connection. Wrappers buffering the response, like BufferWriter,
+// leave out http.Flusher so that streams fail fast rather than being held
+// back.
+//
+// The result unwraps to w for http.ResponseController if wrapper unwraps
+// itself, else to wrapper, so that the controller cannot reach past buffering
+// wrappers and flush the headers the handler has not committed yet.
+func WrapWriter(w http.ResponseWriter, wrapper http.ResponseWriter) http.ResponseWriter {
+	u := unwrapper{wrapper}
+	if _, ok := wrapper.(writerUnwrapper); ok {
+		u = unwrapper{w}
+	}
+
+	var i int
+	f, ok := wrapper.(http.Flusher)
+	if _, ok2 := w.(http.Flusher); ok && ok2 {
+		i |= 1
+	}
+	h, ok := wrapper.(http.Hijacker)
+	if _, ok2 := w.(http.Hijacker); ok && ok2 {
+		i |= 2
+	}
+	p, ok := wrapper.(http.Pusher)
+	if _, ok2 := w.(http.Pusher); ok && ok2 {
+		i |= 4
+	}
+	rf, ok := wrapper.(io.ReaderFrom)
+	if _, ok2 := w.(io.ReaderFrom); ok && ok2 {
+		i |= 8
+	}
+
+	switch i {
+	case 0:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+		}{wrapper, u}
+	case 1:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Flusher
+		}{wrapper, u, f}
+	case 2:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Hijacker
+		}{wrapper, u, h}
+	case 3:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Flusher
+			http.Hijacker
+		}{wrapper, u, f, h}
+	case 4:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Pusher
+		}{wrapper, u, p}
+	case 5:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Flusher
+			http.Pusher
+		}{wrapper, u, f, p}
+	case 6:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Hijacker
+			http.Pusher
+		}{wrapper, u, h, p}
+	case 7:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{wrapper, u, f, h, p}
+	case 8:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			io.ReaderFrom
+		}{wrapper, u, rf}
+	case 9:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Flusher
+			io.ReaderFrom
+		}{wrapper, u, f, rf}
+	case 10:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Hijacker
+			io.ReaderFrom
+		}{wrapper, u, h, rf}
+	case 11:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{wrapper, u, f, h, rf}
+	case 12:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Pusher
+			io.ReaderFrom
+		}{wrapper, u, p, rf}
+	case 13:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{wrapper, u, f, p, rf}
+	case 14:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{wrapper, u, h, p, rf}
+	case 15:
+		return struct {
+			http.ResponseWriter
+			writerUnwrapper
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{wrapper, u, f, h, p, rf}
+	}
+	panic("unreachable")
+}
+
+// push forwards to the Pusher of w
+func push(w http.ResponseWriter, target string, opts *http.PushOptions) error {
+	if p, ok := w.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
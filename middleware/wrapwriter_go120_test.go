@@ -0,0 +1,55 @@
+//go:build go1.20
+// +build go1.20
+
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	. "github.com/rayark/zin/v2/middleware"
+)
+
+func TestResponseControllerFlush(t *testing.T) {
+	var flushErr error
+	router := httprouter.New()
+	grp := zin.NewGroup("/", HMACSHA1Signer(hmacHeaderKey, "", []byte(secretString)), Logger(newRecordEntry()))
+	grp.R(router.GET, "/", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Header().Set("X-App", "1")
+		flushErr = http.NewResponseController(w).Flush()
+		w.Write([]byte(bodyContent))
+	})
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// the controller cannot flush past the signer, whose headers would be
+	// lost
+	if !errors.Is(flushErr, http.ErrNotSupported) {
+		t.Errorf("expected flush not supported got %v", flushErr)
+	}
+	if resp.Header.Get("X-App") != "1" || resp.Header.Get(hmacHeaderKey) == "" {
+		t.Errorf("expected the buffered headers sent got %v", resp.Header)
+	}
+}
@@ -0,0 +1,84 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package middleware_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	. "github.com/rayark/zin/v2/middleware"
+)
+
+// fullRecorder is a recorder supporting every optional interface
+type fullRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r fullRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, nil
+}
+func (r fullRecorder) Push(string, *http.PushOptions) error { return nil }
+func (r fullRecorder) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func writerInterfaces(w http.ResponseWriter) (flusher, hijacker, pusher, readerFrom bool) {
+	_, flusher = w.(http.Flusher)
+	_, hijacker = w.(http.Hijacker)
+	_, pusher = w.(http.Pusher)
+	_, readerFrom = w.(io.ReaderFrom)
+	return
+}
+
+func TestWrapWriterInterfaces(t *testing.T) {
+	wrappers := map[string]zin.Middleware{
+		"logger":     Logger(newRecordEntry()),
+		"compressor": Compressor,
+		"hmac":       HMACSHA1Signer(hmacHeaderKey, "", []byte(secretString)),
+	}
+	// buffering wrappers cannot flush
+	buffered := map[string]bool{"hmac": true}
+
+	for name, m := range wrappers {
+		for _, w := range []http.ResponseWriter{httptest.NewRecorder(), fullRecorder{httptest.NewRecorder()}} {
+			var got http.ResponseWriter
+			router := httprouter.New()
+			grp := zin.NewGroup("/", m)
+			grp.R(router.GET, "/", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+				got = w
+			})
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			router.ServeHTTP(w, req)
+
+			f, h, p, rf := writerInterfaces(got)
+			ef, eh, ep, erf := writerInterfaces(w)
+			ef = ef && !buffered[name]
+			if f != ef || h != eh || p != ep || rf != erf {
+				t.Errorf("%s over %T: expected interfaces %v %v %v %v got %v %v %v %v", name, w, ef, eh, ep, erf, f, h, p, rf)
+			}
+
+			// buffering wrappers must not be unwrapped past
+			u, ok := got.(interface{ Unwrap() http.ResponseWriter })
+			if !ok || (u.Unwrap() == w) == buffered[name] {
+				t.Errorf("%s over %T: expected to unwrap to the underlying writer unless buffered", name, w)
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package zin
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2/middleware"
+)
+
+// Variant is one of the handlers serving a split route
+type Variant struct {
+	Name   string
+	Handle httprouter.Handle
+	Weight int
+
+	// Match pins the requests it returns true for to this variant,
+	// regardless of weights. Variants are matched in registration order.
+	Match func(r *http.Request) bool
+}
+
+// KeyFunc extracts the key used for sticky splitting from a request
+type KeyFunc func(r *http.Request) string
+
+// HeaderKey returns a KeyFunc reading the key from the request header
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// CookieKey returns a KeyFunc reading the key from the request cookie
+func CookieKey(name string) KeyFunc {
+	return func(r *http.Request) string {
+		c, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return c.Value
+	}
+}
+
+// Splitter distributes the traffic of one route between several variants.
+// Requests are assigned randomly by weight, or by hashing the sticky key when
+// one is configured and present in the request. The first variant serves the
+// request if all weights are zero.
+type Splitter struct {
+	variants []Variant
+	key      KeyFunc
+
+	mutex   sync.RWMutex
+	weights []int
+	total   int
+}
+
+func NewSplitter(variants ...Variant) *Splitter {
+	s := &Splitter{
+		variants: variants,
+		weights:  make([]int, len(variants)),
+	}
+	for i, v := range variants {
+		s.weights[i] = v.Weight
+		s.total += v.Weight
+	}
+	return s
+}
+
+// Sticky makes requests carrying the same key always served by the same
+// variant as long as weights stay unchanged
+func (s *Splitter) Sticky(key KeyFunc) *Splitter {
+	s.key = key
+	return s
+}
+
+// SetWeight changes the weight of the named variant at runtime
+func (s *Splitter) SetWeight(name string, weight int) error {
+	return s.SetWeights(map[string]int{name: weight})
+}
+
+// SetWeights changes the weights of the named variants at once, variants not
+// in weights keep their current weight
+func (s *Splitter) SetWeights(weights map[string]int) error {
+	for name, weight := range weights {
+		if weight < 0 {
+			return fmt.Errorf("zin: negative weight %d for variant %q", weight, name)
+		}
+		if s.index(name) < 0 {
+			return fmt.Errorf("zin: unknown variant %q", name)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for name, weight := range weights {
+		i := s.index(name)
+		s.total += weight - s.weights[i]
+		s.weights[i] = weight
+	}
+	return nil
+}
+
+// Weights returns the current weight of each variant
+func (s *Splitter) Weights() map[string]int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	weights := make(map[string]int, len(s.variants))
+	for i, v := range s.variants {
+		weights[v.Name] = s.weights[i]
+	}
+	return weights
+}
+
+func (s *Splitter) index(name string) int {
+	for i, v := range s.variants {
+		if v.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Splitter) pick(r *http.Request) int {
+	for i, v := range s.variants {
+		if v.Match != nil && v.Match(r) {
+			return i
+		}
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.total <= 0 {
+		return 0
+	}
+
+	var n int
+	if key := s.stickyKey(r); key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		n = int(h.Sum32() % uint32(s.total))
+	} else {
+		n = rand.Intn(s.total)
+	}
+
+	for i, weight := range s.weights {
+		if n < weight {
+			return i
+		}
+		n -= weight
+	}
+	return 0
+}
+
+func (s *Splitter) stickyKey(r *http.Request) string {
+	if s.key == nil {
+		return ""
+	}
+	return s.key(r)
+}
+
+// RS registers the variants of splitter on the path. Each variant runs
+// through the middlewares of the group, with its name stored in the request
+// context for Logger.
+func (g *MuxGroup) RS(r RegisterFunc, p string, s *Splitter) {
+	if len(s.variants) == 0 {
+		panic("zin: splitter without variants")
+	}
+
+	route := g.Path(p)
+	handles := make([]httprouter.Handle, len(s.variants))
+	for i, v := range s.variants {
+		m := safeAppend(g.middlewares,
+			middleware.AddRouteToContext(route),
+			middleware.AddVariantToContext(v.Name))
+		handles[i] = makePooledHandle(m, v.Handle)
+	}
+
+	r(route, func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		handles[s.pick(req)](w, req, p)
+	})
+}
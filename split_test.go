@@ -0,0 +1,113 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package zin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2/middleware"
+)
+
+func splitTestRouter(s *Splitter) *httprouter.Router {
+	router := httprouter.New()
+	group := NewGroup("/")
+	group.RS(router.GET, "/split", s)
+	return router
+}
+
+func splitTestHandle(name string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		variant, _ := middleware.GetVariantFromContext(r.Context())
+		fmt.Fprint(w, name+":"+variant)
+	}
+}
+
+func splitTestServe(router *httprouter.Router, header http.Header) string {
+	r, err := http.NewRequest("GET", "http://example.com/split", nil)
+	if err != nil {
+		panic(err)
+	}
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w.Body.String()
+}
+
+func TestSplitterWeights(t *testing.T) {
+	s := NewSplitter(
+		Variant{Name: "old", Handle: splitTestHandle("A"), Weight: 1},
+		Variant{Name: "new", Handle: splitTestHandle("B"), Weight: 0},
+	)
+	router := splitTestRouter(s)
+
+	for i := 0; i < 20; i++ {
+		if body := splitTestServe(router, nil); body != "A:old" {
+			t.Fatalf("expected A:old got %s", body)
+		}
+	}
+
+	if err := s.SetWeights(map[string]int{"old": 0, "new": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if body := splitTestServe(router, nil); body != "B:new" {
+			t.Fatalf("expected B:new got %s", body)
+		}
+	}
+
+	if err := s.SetWeight("unknown", 1); err == nil {
+		t.Fatalf("expected error for unknown variant")
+	}
+}
+
+func TestSplitterSticky(t *testing.T) {
+	s := NewSplitter(
+		Variant{Name: "old", Handle: splitTestHandle("A"), Weight: 50},
+		Variant{Name: "new", Handle: splitTestHandle("B"), Weight: 50},
+	).Sticky(HeaderKey("X-Player-Id"))
+	router := splitTestRouter(s)
+
+	for i := 0; i < 10; i++ {
+		header := http.Header{"X-Player-Id": []string{fmt.Sprint("player-", i)}}
+		first := splitTestServe(router, header)
+		for j := 0; j < 10; j++ {
+			if body := splitTestServe(router, header); body != first {
+				t.Fatalf("expected %s got %s", first, body)
+			}
+		}
+	}
+}
+
+func TestSplitterMatch(t *testing.T) {
+	s := NewSplitter(
+		Variant{Name: "old", Handle: splitTestHandle("A"), Weight: 1},
+		Variant{Name: "new", Handle: splitTestHandle("B"), Match: func(r *http.Request) bool {
+			return r.Header.Get("X-Canary") == "1"
+		}},
+	)
+	router := splitTestRouter(s)
+
+	if body := splitTestServe(router, http.Header{"X-Canary": []string{"1"}}); body != "B:new" {
+		t.Fatalf("expected B:new got %s", body)
+	}
+
+	if body := splitTestServe(router, nil); body != "A:old" {
+		t.Fatalf("expected A:old got %s", body)
+	}
+}
@@ -0,0 +1,183 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+// Package sse implements Server-Sent Events streams on top of zin routes
+package sse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	ErrFlushNotSupported = errors.New("sse: ResponseWriter does not implement http.Flusher")
+	ErrInvalidField      = errors.New("sse: event and id must not contain line breaks")
+)
+
+// Event is a message of the stream, empty fields are omitted
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// Stream writes events to a client. Its methods are safe for concurrent use.
+type Stream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	ctx     context.Context
+
+	lastEventID string
+
+	mutex sync.Mutex
+}
+
+// NewStream replies the event stream headers and returns the stream of the
+// request. Handlers in front of it must pass http.Flusher through.
+func NewStream(w http.ResponseWriter, r *http.Request) (*Stream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrFlushNotSupported
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	header.Del("Content-Length")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &Stream{
+		w:           w,
+		flusher:     flusher,
+		ctx:         r.Context(),
+		lastEventID: r.Header.Get("Last-Event-ID"),
+	}, nil
+}
+
+// LastEventID returns the id of the last event received by a reconnecting
+// client, the stream should resume after it
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed when the client disconnects
+func (s *Stream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Send writes the event and flushes it to the client
+func (s *Stream) Send(e Event) error {
+	if strings.ContainsAny(e.ID, "\r\n\x00") || strings.ContainsAny(e.Event, "\r\n") {
+		return ErrInvalidField
+	}
+
+	var buf bytes.Buffer
+	if e.ID != "" {
+		buf.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		buf.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.Replace(e.Data, "\r\n", "\n", -1)
+	data = strings.Replace(data, "\r", "\n", -1)
+	for _, line := range strings.Split(data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+
+	return s.write(buf.Bytes())
+}
+
+// Retry tells the client how long to wait before reconnecting
+func (s *Stream) Retry(d time.Duration) error {
+	return s.write([]byte("retry: " + strconv.FormatInt(d.Milliseconds(), 10) + "\n\n"))
+}
+
+// Comment writes a comment line, which clients ignore. It keeps idle
+// connections alive through proxies.
+func (s *Stream) Comment(text string) error {
+	var buf bytes.Buffer
+	for _, line := range strings.Split(text, "\n") {
+		buf.WriteString(": " + strings.TrimRight(line, "\r") + "\n")
+	}
+	buf.WriteString("\n")
+	return s.write(buf.Bytes())
+}
+
+func (s *Stream) write(b []byte) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Handle returns a route handle opening the stream and serving it with fn.
+// If heartbeat is positive, a comment is sent at that interval while fn
+// runs. Requests whose writer cannot flush are replied with 500.
+func Handle(heartbeat time.Duration, fn func(s *Stream, r *http.Request, p httprouter.Params)) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s, err := NewStream(w, r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if heartbeat > 0 {
+			var wg sync.WaitGroup
+			done := make(chan struct{})
+			defer wg.Wait()
+			defer close(done)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ticker := time.NewTicker(heartbeat)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						if s.Comment("heartbeat") != nil {
+							return
+						}
+					case <-done:
+						return
+					case <-s.Done():
+						return
+					}
+				}
+			}()
+		}
+
+		fn(s, r, p)
+	}
+}
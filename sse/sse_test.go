@@ -0,0 +1,155 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package sse_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	"github.com/rayark/zin/v2/middleware"
+	. "github.com/rayark/zin/v2/sse"
+)
+
+type nopEntry struct{}
+
+func (e nopEntry) WithField(string, interface{}) middleware.LogEntry { return e }
+func (e nopEntry) Infof(string, ...interface{})                      {}
+func (e nopEntry) Warningf(string, ...interface{})                   {}
+func (e nopEntry) Errorf(string, ...interface{})                     {}
+
+func readEvent(t *testing.T, br *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\n" {
+			return lines
+		}
+		lines = append(lines, line[:len(line)-1])
+	}
+}
+
+func sseTest(t *testing.T, reqHeaders map[string]string, decode func(io.Reader) io.Reader, middlewares ...zin.Middleware) {
+	release := make(chan struct{})
+
+	router := httprouter.New()
+	grp := zin.NewGroup("/", append(middlewares, middleware.Compressor, middleware.Logger(nopEntry{}))...)
+	grp.R(router.GET, "/events", Handle(0, func(s *Stream, r *http.Request, p httprouter.Params) {
+		s.Send(Event{ID: s.LastEventID() + "1", Event: "greeting", Data: "hello\nworld"})
+		<-release
+		s.Send(Event{Data: "bye"})
+	}))
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range reqHeaders {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected text/event-stream got '%s'", resp.Header.Get("Content-Type"))
+	}
+
+	br := bufio.NewReader(decode(resp.Body))
+
+	// the first event must arrive while the handler is still blocked
+	lines := readEvent(t, br)
+	expected := []string{"id: 41", "event: greeting", "data: hello", "data: world"}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, lines)
+	}
+	for i := range lines {
+		if lines[i] != expected[i] {
+			t.Fatalf("expected %v got %v", expected, lines)
+		}
+	}
+
+	close(release)
+
+	lines = readEvent(t, br)
+	if len(lines) != 1 || lines[0] != "data: bye" {
+		t.Fatalf("expected [data: bye] got %v", lines)
+	}
+}
+
+func TestStream(t *testing.T) {
+	sseTest(t, map[string]string{"Last-Event-ID": "4"}, func(r io.Reader) io.Reader {
+		return r
+	})
+}
+
+func TestStreamThroughCompressor(t *testing.T) {
+	sseTest(t, map[string]string{"Last-Event-ID": "4", "Accept-Encoding": "gzip"}, func(r io.Reader) io.Reader {
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gzr
+	})
+}
+
+func TestStreamThroughTrailerSigner(t *testing.T) {
+	config := middleware.HMACSHA1Config("X-Signature", "", []byte("secret"))
+	config.Trailer = true
+	sseTest(t, map[string]string{"Last-Event-ID": "4"}, func(r io.Reader) io.Reader {
+		return r
+	}, middleware.HMACSigner(config))
+}
+
+func TestStreamBehindSigner(t *testing.T) {
+	called := false
+	router := httprouter.New()
+	grp := zin.NewGroup("/", middleware.HMACSHA1Signer("X-Signature", "", []byte("secret")))
+	grp.R(router.GET, "/events", Handle(0, func(s *Stream, r *http.Request, p httprouter.Params) {
+		called = true
+	}))
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	// the signer buffers the whole response, the stream fails at once
+	// rather than holding back its events
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || called {
+		t.Fatalf("expected stream refused behind the signer got %d", resp.StatusCode)
+	}
+}
+
+func TestStreamNotBuffered(t *testing.T) {
+	req := httptest.NewRequest("GET", "/events", nil)
+	if _, err := NewStream(middleware.NewDeferWriter(httptest.NewRecorder()), req); err != ErrFlushNotSupported {
+		t.Fatalf("expected ErrFlushNotSupported behind a buffer got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+/* (C)2023 Rayark Inc. - All Rights Reserved
+ * Rayark Confidential
+ *
+ * NOTICE: The intellectual and technical concepts contained herein are
+ * proprietary to or under control of Rayark Inc. and its affiliates.
+ * The information herein may be covered by patents, patents in process,
+ * and are protected by trade secret or copyright law.
+ * You may not disseminate this information or reproduce this material
+ * unless otherwise prior agreed by Rayark Inc. in writing.
+ */
+
+package websocket_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rayark/zin/v2"
+	"github.com/rayark/zin/v2/middleware"
+	. "github.com/rayark/zin/v2/websocket"
+)
+
+type nopEntry struct{}
+
+func (e nopEntry) WithField(string, interface{}) middleware.LogEntry { return e }
+func (e nopEntry) Infof(string, ...interface{})                      {}
+func (e nopEntry) Warningf(string, ...interface{})                   {}
+func (e nopEntry) Errorf(string, ...interface{})                     {}
+
+func newEchoServer() *httptest.Server {
+	router := httprouter.New()
+	grp := zin.NewGroup("/",
+		middleware.Compressor,
+		middleware.HMACSHA1Signer("HMAC-Signature-Hash", "", []byte("secret")),
+		middleware.Logger(nopEntry{}),
+	)
+	grp.R(router.GET, "/ws", Handle(&Upgrader{Subprotocols: []string{"echo"}}, func(c *Conn, r *http.Request, p httprouter.Params) {
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(mt, msg); err != nil {
+				return
+			}
+		}
+	}))
+	return httptest.NewServer(router)
+}
+
+func dial(t *testing.T, srv *httptest.Server) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := "GET /ws HTTP/1.1\r\n" +
+		"Host: " + strings.TrimPrefix(srv.URL, "http://") + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Accept-Encoding: gzip\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Protocol: chat, echo\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := io.WriteString(conn, req); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, resp
+}
+
+func writeClientFrame(t *testing.T, conn net.Conn, fin bool, opcode byte, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{opcode, 0x80 | byte(len(payload))}
+	if fin {
+		frame[0] |= 0x80
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readServerFrame(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	var header [2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatalf("server frame must not be masked")
+	}
+	payload := make([]byte, header[1]&0x7f)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+func TestHandshakeThroughMiddlewares(t *testing.T) {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	conn, br, resp := dial(t, srv)
+	defer conn.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101 got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected Sec-WebSocket-Accept '%s'", resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	if resp.Header.Get("Sec-WebSocket-Protocol") != "echo" {
+		t.Fatalf("expected subprotocol echo got '%s'", resp.Header.Get("Sec-WebSocket-Protocol"))
+	}
+
+	writeClientFrame(t, conn, false, TextMessage, []byte("hel"))
+	writeClientFrame(t, conn, true, PingMessage, []byte("p"))
+	writeClientFrame(t, conn, true, 0, []byte("lo"))
+
+	if opcode, payload := readServerFrame(t, br); opcode != PongMessage || string(payload) != "p" {
+		t.Fatalf("expected pong 'p' got %d '%s'", opcode, payload)
+	}
+	if opcode, payload := readServerFrame(t, br); opcode != TextMessage || string(payload) != "hello" {
+		t.Fatalf("expected text 'hello' got %d '%s'", opcode, payload)
+	}
+
+	closePayload := make([]byte, 2)
+	binary.BigEndian.PutUint16(closePayload, CloseGoingAway)
+	writeClientFrame(t, conn, true, CloseMessage, closePayload)
+
+	opcode, payload := readServerFrame(t, br)
+	if opcode != CloseMessage || binary.BigEndian.Uint16(payload) != CloseGoingAway {
+		t.Fatalf("expected close echo got %d '%v'", opcode, payload)
+	}
+}
+
+func TestHandshakeRejected(t *testing.T) {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 got %d", resp.StatusCode)
+	}
+}
+
+func TestHijackNotSupported(t *testing.T) {
+	w := middleware.NewProxyWriter(httptest.NewRecorder())
+	if _, _, err := w.Hijack(); err != middleware.ErrHijackNotSupported {
+		t.Fatalf("expected ErrHijackNotSupported got %v", err)
+	}
+}
@@ -105,6 +105,16 @@ func (g *MuxGroup) Path(p string) string {
 	return pathJoin(g.basePath, p)
 }
 
+// ServeFiles serves files from root through the middlewares of the group,
+// serving precompressed variants when accepted. The path must end with
+// "/*filepath" as in httprouter.Router.ServeFiles.
+func (g *MuxGroup) ServeFiles(r RegisterFunc, p string, root http.FileSystem) {
+	if len(p) < 10 || p[len(p)-10:] != "/*filepath" {
+		panic("path must end with /*filepath in path '" + p + "'")
+	}
+	g.R(r, p, middleware.StaticFiles(root))
+}
+
 func (g *MuxGroup) NotFound(h http.Handler) http.Handler {
 	handle := makePooledHandle(g.middlewares, WrapH(h))
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {